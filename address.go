@@ -0,0 +1,102 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// headerLineLength 邮件头折行的建议行长度（RFC 5322 2.1.1节）
+const headerLineLength = 78
+
+// AddressList 邮件地址列表，用于构造To、Cc等邮件头
+type AddressList []mail.Address
+
+// Add 解析并添加地址，raw可以是"名称 <地址>"或裸地址
+// 无效的地址被跳过，返回的错误汇总所有无效的地址，有效的地址仍会被添加
+func (l *AddressList) Add(raw ...string) error {
+	var errs []error
+	for _, s := range raw {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("email: invalid address %q: %w", s, err))
+			continue
+		}
+		*l = append(*l, *addr)
+	}
+	return errors.Join(errs...)
+}
+
+// Dedupe 返回去除重复地址后的列表，地址不区分大小写，保留第一次出现的地址
+func (l AddressList) Dedupe() AddressList {
+	return l.DedupeBy(strings.ToLower)
+}
+
+// DedupeBy 按canonical规范化后的地址去重，保留第一次出现的原始地址，只影响去重而不改写投递的地址
+// canonical为nil时与Dedupe相同；GmailCanonical可以作为示例
+func (l AddressList) DedupeBy(canonical func(address string) string) AddressList {
+	if canonical == nil {
+		canonical = strings.ToLower
+	}
+	seen := make(map[string]bool, len(l))
+	deduped := make(AddressList, 0, len(l))
+	for _, addr := range l {
+		key := canonical(addr.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, addr)
+	}
+	return deduped
+}
+
+// GmailCanonical 按Gmail的规则规范化地址：gmail.com和googlemail.com的本地部分去掉"+"之后的标签和所有的点，
+// 域名统一为gmail.com；其他域名的地址只转换为小写，因为并非所有服务商都忽略点和标签
+func GmailCanonical(address string) string {
+	address = strings.ToLower(address)
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return address
+	}
+	local, domain := address[:at], address[at+1:]
+	if domain != "gmail.com" && domain != "googlemail.com" {
+		return address
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
+
+// String 返回以逗号分隔的地址列表，不折行
+func (l AddressList) String() string {
+	list := make([]string, 0, len(l))
+	for _, addr := range l {
+		list = append(list, addr.String())
+	}
+	return strings.Join(list, ", ")
+}
+
+// Format 返回field头的值：ASCII显示名称加引号，格式为"名称" <地址>，名称中的引号和反斜杠会被转义；
+// 非ASCII名称按RFC 2047编码且不加引号，如=?utf-8?q?...?= <地址>；没有显示名称时为<地址>；
+// 地址之间以逗号分隔，包括"field: "在内超过78个字符时在地址之间折行
+func (l AddressList) Format(field string) string {
+	var b strings.Builder
+	line := len(field) + len(": ")
+	for i, addr := range l {
+		s := addr.String()
+		if i > 0 {
+			b.WriteByte(',')
+			if line+len(", ")+len(s) > headerLineLength {
+				b.WriteString("\r\n ")
+				line = len(" ")
+			} else {
+				b.WriteString(" ")
+				line += len(", ")
+			}
+		}
+		b.WriteString(s)
+		line += len(s)
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestAddressList_Add tests parsing, deduplicating and formatting an address list
+func TestAddressList_Add(t *testing.T) {
+	var list AddressList
+	err := list.Add(
+		"张三 <zhangsan@example.com>",
+		"not an address",
+		"bob@example.com",
+		"BOB@example.com",
+		"<broken@",
+	)
+	if err == nil {
+		t.Fatal("expected errors for invalid entries")
+	}
+	for _, bad := range []string{`"not an address"`, `"<broken@"`} {
+		if !strings.Contains(err.Error(), bad) {
+			t.Errorf("expected error for %s, got %v", bad, err)
+		}
+	}
+	list = list.Dedupe()
+	if len(list) != 2 {
+		t.Fatalf("expected 2 addresses after dedupe, got %v", list)
+	}
+	if got, want := list.Format("To"), "=?utf-8?q?=E5=BC=A0=E4=B8=89?= <zhangsan@example.com>, <bob@example.com>"; got != want {
+		t.Errorf("unexpected header value:\n got %q\nwant %q", got, want)
+	}
+	ascii := AddressList{{Name: `Bob "B"`, Address: "bob@example.com"}}
+	if got, want := ascii.Format("To"), `"Bob \"B\"" <bob@example.com>`; got != want {
+		t.Errorf("unexpected header value:\n got %q\nwant %q", got, want)
+	}
+
+	// 较长的列表在地址之间折行，每行不超过78个字符
+	long := make(AddressList, 6)
+	for i := range long {
+		long[i] = mail.Address{Address: strings.Repeat("x", 20) + "@example.com"}
+	}
+	lines := strings.Split("To: "+long.Format("To"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected folded header, got %q", lines)
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, " ") {
+			t.Errorf("continuation line must start with whitespace: %q", line)
+		}
+	}
+	for _, line := range lines {
+		if len(line) > headerLineLength {
+			t.Errorf("line longer than %d characters: %q", headerLineLength, line)
+		}
+	}
+	parsed, err := mail.ParseAddressList(strings.ReplaceAll(long.Format("To"), "\r\n", ""))
+	if err != nil || len(parsed) != len(long) {
+		t.Errorf("folded header does not parse back: %v %v", parsed, err)
+	}
+}
+
+// TestAddressList_DedupeBy tests deduplicating with a canonicalization function while keeping the first original address
+func TestAddressList_DedupeBy(t *testing.T) {
+	list := AddressList{
+		{Name: "User", Address: "user+a@gmail.com"},
+		{Address: "user@gmail.com"},
+		{Address: "U.S.E.R@googlemail.com"},
+		{Address: "user+a@example.com"},
+		{Address: "user@example.com"},
+	}
+	if got := list.Dedupe(); len(got) != len(list) {
+		t.Errorf("expected Dedupe to keep distinct addresses, got %v", got)
+	}
+	deduped := list.DedupeBy(GmailCanonical)
+	want := AddressList{{Name: "User", Address: "user+a@gmail.com"}, {Address: "user+a@example.com"}, {Address: "user@example.com"}}
+	if len(deduped) != len(want) {
+		t.Fatalf("expected %v, got %v", want, deduped)
+	}
+	for i := range want {
+		if deduped[i] != want[i] {
+			t.Errorf("expected the first original address %v, got %v", want[i], deduped[i])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+)
+
+// begin 登记一个进行中的异步发送
+func (m *Email) begin() {
+	m.inflightMu.Lock()
+	defer m.inflightMu.Unlock()
+	if m.inflight == 0 {
+		m.drained = make(chan struct{})
+	}
+	m.inflight++
+}
+
+// end 结束一个异步发送，全部完成时通知等待中的Drain
+func (m *Email) end() {
+	m.inflightMu.Lock()
+	defer m.inflightMu.Unlock()
+	m.inflight--
+	if m.inflight == 0 {
+		close(m.drained)
+	}
+}
+
+// SendAsync 在后台发送邮件并立即返回，发送方式与SendWithOptions相同
+// done不为nil时，全部收件人发送完成后以与toList一一对应的结果调用done
+// 程序退出前应调用Drain等待发送完成
+func (m *Email) SendAsync(fromName string, toList []mail.Address, subject, content string, opts SendOptions, done func([]SendResult)) {
+	m.begin()
+	go func() {
+		defer m.end()
+		results := m.send(fromName, toList, staticContent(subject, content), opts, nil)
+		if done != nil {
+			done(results)
+		}
+	}()
+}
+
+// Drain 阻塞直到所有进行中的SendAsync和SendStream发送完成，用于程序退出前确保邮件不丢失
+// ctx被取消时返回ctx的错误，此时仍有发送未完成
+func (m *Email) Drain(ctx context.Context) error {
+	m.inflightMu.Lock()
+	if m.inflight == 0 {
+		m.inflightMu.Unlock()
+		return nil
+	}
+	drained := m.drained
+	m.inflightMu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEmail_Drain tests that Drain waits for all asynchronous sends to complete
+func TestEmail_Drain(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if cmd == "." {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	var completed atomic.Int32
+	const sends = 5
+	for i := 0; i < sends; i++ {
+		to := []mail.Address{{Address: fmt.Sprintf("user%d@example.com", i)}}
+		email.SendAsync("", to, "subject", "content", SendOptions{}, func(results []SendResult) {
+			if results[0].Err == nil {
+				completed.Add(1)
+			}
+		})
+	}
+	if err := email.Drain(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := completed.Load(); got != sends {
+		t.Errorf("expected %d completed sends before Drain returned, got %d", sends, got)
+	}
+	if got := len(server.received()); got != sends {
+		t.Errorf("expected %d messages, got %d", sends, got)
+	}
+}
+
+// TestEmail_DrainCanceled tests that Drain returns the ctx error when sends are still running
+func TestEmail_DrainCanceled(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if cmd == "." {
+				time.Sleep(300 * time.Millisecond)
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	email.SendAsync("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := email.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if err := email.Drain(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
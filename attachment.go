@@ -0,0 +1,87 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// encodeBase64 附件内容的base64编码函数，按RFC 2045每行76个字符折行
+var encodeBase64 = func(data []byte) string {
+	return wrapBase64(base64.StdEncoding.EncodeToString(data))
+}
+
+// Attachment 邮件附件
+// 通过NewAttachment创建的附件在首次使用时计算并缓存编码结果，同一附件发送给多个收件人时只编码一次；
+// 缓存按内容的SHA-256校验，复制附件或修改Data后仍得到正确的编码
+type Attachment struct {
+	Filename    string
+	ContentType string // 为空时根据文件扩展名推断，无法推断时使用application/octet-stream
+	Data        []byte
+
+	encoding *encodingCache
+}
+
+// encodingCache 附件base64编码的缓存，复制的附件共享同一个缓存
+type encodingCache struct {
+	mu      sync.Mutex
+	sum     [sha256.Size]byte
+	encoded string
+}
+
+// NewAttachment 创建附件，根据文件扩展名推断内容类型
+func NewAttachment(filename string, data []byte) *Attachment {
+	return &Attachment{
+		Filename: filename,
+		Data:     data,
+		encoding: &encodingCache{},
+	}
+}
+
+// mediaType 返回附件的内容类型
+func (a *Attachment) mediaType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Filename)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// encodedData 返回附件内容的base64编码，并发安全；没有缓存的附件（未通过NewAttachment创建）每次重新编码
+func (a *Attachment) encodedData() string {
+	if a.encoding == nil {
+		return encodeBase64(a.Data)
+	}
+	sum := sha256.Sum256(a.Data)
+	a.encoding.mu.Lock()
+	defer a.encoding.mu.Unlock()
+	if a.encoding.encoded == "" || a.encoding.sum != sum {
+		a.encoding.sum, a.encoding.encoded = sum, encodeBase64(a.Data)
+	}
+	return a.encoding.encoded
+}
+
+// SendFile 将文件作为附件发送给单个收件人，note为正文
+// 内容类型根据文件扩展名推断，无法推断时根据文件内容检测
+func (m *Email) SendFile(fromName string, to mail.Address, subject, note, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("email: failed to read attachment: %w", err)
+	}
+	attachment := NewAttachment(filepath.Base(filePath), data)
+	if mime.TypeByExtension(filepath.Ext(filePath)) == "" {
+		attachment.ContentType = http.DetectContentType(data)
+	}
+	results := m.SendWithOptions(fromName, []mail.Address{to}, subject, note, SendOptions{
+		Attachments: []*Attachment{attachment},
+	})
+	return results[0].Err
+}
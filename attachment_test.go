@@ -0,0 +1,160 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestAttachment_EncodedOnce tests that a shared attachment is encoded once for all recipients
+func TestAttachment_EncodedOnce(t *testing.T) {
+	var calls atomic.Int32
+	original := encodeBase64
+	encodeBase64 = func(data []byte) string {
+		calls.Add(1)
+		return original(data)
+	}
+	defer func() { encodeBase64 = original }()
+
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	var toList []mail.Address
+	for i := 0; i < 5; i++ {
+		toList = append(toList, mail.Address{Address: fmt.Sprintf("user%d@example.com", i)})
+	}
+	attachment := NewAttachment("report.pdf", []byte("%PDF-1.4 shared report"))
+	results := email.SendWithOptions("测试发件人", toList, "月度报告", "请查收附件", SendOptions{
+		Attachments: []*Attachment{attachment},
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected attachment to be encoded once, got %d", got)
+	}
+	if received := server.received(); len(received) != len(toList) {
+		t.Errorf("expected %d messages, got %d", len(toList), len(received))
+	}
+}
+
+// TestAttachment_CopyAndModify tests that a copied or modified attachment is not served a stale encoding
+func TestAttachment_CopyAndModify(t *testing.T) {
+	original := NewAttachment("a.txt", []byte("first"))
+	if got := original.encodedData(); got != "Zmlyc3Q=" {
+		t.Fatalf("unexpected encoding %q", got)
+	}
+	copied := *original
+	copied.Data = []byte("second")
+	if got := copied.encodedData(); got != "c2Vjb25k" {
+		t.Errorf("expected the copy to be encoded from its own data, got %q", got)
+	}
+	if got := original.encodedData(); got != "Zmlyc3Q=" {
+		t.Errorf("expected the original encoding, got %q", got)
+	}
+	if got := (&Attachment{Filename: "b.txt", Data: []byte("third")}).encodedData(); got != "dGhpcmQ=" {
+		t.Errorf("expected an attachment literal to be encoded, got %q", got)
+	}
+}
+
+// TestBuildMessage_Attachment tests the multipart/mixed structure with an attachment
+func TestBuildMessage_Attachment(t *testing.T) {
+	message, err := (&Email{}).buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"报告", "请查收附件", SendOptions{Attachments: []*Attachment{NewAttachment("报告.pdf", []byte("data"))}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("expected MIME-Version header, got %q", msg.Header.Get("MIME-Version"))
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed, got %q (%v)", mediaType, err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mr.NextPart()
+	if err != nil || !strings.HasPrefix(body.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected text/plain body part, got %v (%v)", body, err)
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("expected attachment part: %v", err)
+	}
+	if part.FileName() != "报告.pdf" || part.Header.Get("Content-Type") != mime.FormatMediaType("application/pdf", map[string]string{"name": "报告.pdf"}) {
+		t.Errorf("unexpected attachment headers: %v", part.Header)
+	}
+	data, _ := io.ReadAll(part)
+	if got := decodeBase64(string(data)); got != "data" {
+		t.Errorf("unexpected attachment data %q", got)
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got %v", err)
+	}
+}
+
+// TestEmail_SendFile tests sending a file as an attachment with a detected content type
+func TestEmail_SendFile(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	path := filepath.Join(t.TempDir(), "notes")
+	if err := os.WriteFile(path, []byte("plain text notes"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := email.SendFile("", mail.Address{Address: "rcpt@example.com"}, "notes", "see attached", path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(received))
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(received[0]))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed, got %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("failed to read body part: %v", err)
+	}
+	if content, _ := io.ReadAll(body); string(content) != "see attached" {
+		t.Errorf("unexpected body %q", content)
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("failed to read attachment part: %v", err)
+	}
+	if part.FileName() != "notes" {
+		t.Errorf("unexpected filename %q", part.FileName())
+	}
+	if got := part.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("expected detected text/plain content type, got %q", got)
+	}
+}
+
+// TestEmail_SendFileMissing tests the error for a file that cannot be read
+func TestEmail_SendFileMissing(t *testing.T) {
+	email := New(map[string]*ConfigMapper{"default": {Host: "127.0.0.1", Port: 25, Username: "sender@example.com", Password: "secret"}})
+	if err := email.SendFile("", mail.Address{Address: "rcpt@example.com"}, "s", "n", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
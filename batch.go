@@ -0,0 +1,242 @@
+package email
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNotAttempted BatchSend的ctx结束时尚未开始投递的邮件的错误，同时包装ctx的错误
+var ErrNotAttempted = errors.New("email: not attempted")
+
+// ctxErr 返回ctx的错误；截止时间已过但ctx尚未报告超时时也返回context.DeadlineExceeded，
+// 因为连接的截止时间与ctx的截止时间相同，连接超时可能先于ctx被察觉
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// notAttempted 生成未投递邮件的错误
+func notAttempted(err error) error {
+	return fmt.Errorf("%w: %w", ErrNotAttempted, err)
+}
+
+// BatchSend 发送一组相互独立的邮件，返回与msgs一一对应的结果
+// 邮件按第一个信封收件人解析出的配置分组，同一配置的邮件在一个连接上依次投递；
+// 邮件的所有信封收件人都通过该配置投递。路由与Send相同，遵循StrictRouting；开启PreflightRouting时
+// 任一邮件的第一个信封收件人无法路由则不发送任何邮件。From地址为空时使用配置中的Username和FromName。
+// 邮件按Priority从高到低投递，受MaxConcurrency限制时包含高优先级邮件的配置先开始。
+// ctx的截止时间同时是会话的截止时间：到期后尚未开始投递的邮件以ErrNotAttempted结束，
+// 已打开的连接发送QUIT后关闭
+func (m *Email) BatchSend(ctx context.Context, msgs []*Message) []SendResult {
+	results := make([]SendResult, len(msgs))
+	envelopes := make([][]mail.Address, len(msgs))
+	total := 0
+	for i, msg := range msgs {
+		envelopes[i] = envelopeOf(msg)
+		total += len(envelopes[i])
+	}
+	if err := m.checkRecipientCount(total); err != nil {
+		for i := range results {
+			results[i].Err = err
+			if len(envelopes[i]) > 0 {
+				results[i].Recipient = envelopes[i][0]
+			}
+		}
+		return results
+	}
+	// heads为参与路由的第一个信封收件人，owners[n]为heads[n]所属邮件在msgs中的位置
+	var heads []mail.Address
+	var owners []int
+	for i := range msgs {
+		addrs := m.rewriteRecipients(envelopes[i])
+		envelopes[i] = addrs
+		if len(addrs) == 0 {
+			results[i].Err = ErrNoRecipients
+			continue
+		}
+		results[i].Recipient = addrs[0]
+		results[i].Alias = m.isAlias(addrs[0].Address)
+		if err := m.checkRecipients(addrs); err != nil {
+			results[i].Err = err
+			continue
+		}
+		heads = append(heads, addrs[0])
+		owners = append(owners, i)
+	}
+	if failed, ok := m.preflight(heads); !ok {
+		for n, i := range owners {
+			results[i].Err = failed[n].Err
+		}
+		return results
+	}
+	routed := make([]SendResult, len(heads))
+	configs, byHead := m.route(heads, routed, nil)
+	for n, i := range owners {
+		if routed[n].Err != nil {
+			results[i].Err = routed[n].Err
+		}
+	}
+	groups := make(map[*ConfigMapper][]int, len(configs))
+	for _, config := range configs {
+		for _, n := range byHead[config] {
+			groups[config] = append(groups[config], owners[n])
+		}
+	}
+
+	// 同一配置的邮件按Priority从高到低投递，包含更高优先级邮件的配置先占用并发名额
+	priority := func(i int) int { return msgs[i].Priority }
+	for _, config := range configs {
+		slices.SortStableFunc(groups[config], func(a, b int) int { return cmp.Compare(priority(b), priority(a)) })
+	}
+	slices.SortStableFunc(configs, func(a, b *ConfigMapper) int {
+		return cmp.Compare(priority(groups[b][0]), priority(groups[a][0]))
+	})
+
+	var wg sync.WaitGroup
+	sem := newLimiter(m.MaxConcurrency)
+	for _, config := range configs {
+		wg.Add(1)
+		// 按优先级顺序取得并发名额后再启动，保证高优先级的配置先开始投递
+		sem.acquire()
+		go func(config *ConfigMapper, indexes []int) {
+			defer wg.Done()
+			defer sem.release()
+
+			if err := ctx.Err(); err != nil {
+				for _, i := range indexes {
+					results[i].Err = notAttempted(err)
+				}
+				return
+			}
+			var sess *session
+			var release func() error
+			defer func() {
+				if sess == nil {
+					return
+				}
+				// 截止时间已到时会话可能中断在投递中途，结束会话而不归还到连接池
+				if ctxErr(ctx) != nil {
+					sess.close()
+				} else {
+					release()
+				}
+			}()
+
+			for n, i := range indexes {
+				if err := ctxErr(ctx); err != nil {
+					results[i].Err = notAttempted(err)
+					continue
+				}
+				if sess == nil {
+					var err error
+					if sess, release, err = m.openSession(ctx, config); err != nil {
+						for _, i := range indexes[n:] {
+							results[i].Err = err
+						}
+						return
+					}
+				}
+				results[i].Reused = sess.begin()
+				m.batchTransaction(ctx, sess.client, config, msgs[i], envelopes[i], &results[i])
+				// 会话不能继续使用时关闭连接，后续邮件重新建立连接
+				if ctxErr(ctx) == nil && sessionBroken(sess.client, results[i].Err) {
+					_ = sess.close()
+					sess = nil
+				}
+			}
+		}(config, groups[config])
+	}
+	wg.Wait()
+	return results
+}
+
+// batchTransaction 在已建立的会话上投递一封邮件，任一收件人失败时汇总错误
+// addrs为改写后的信封收件人，被BeforeSend跳过的收件人不加入投递
+func (m *Email) batchTransaction(ctx context.Context, smtpClient *smtp.Client, config *ConfigMapper, msg *Message, addrs []mail.Address, result *SendResult) {
+	copied := *msg
+	if copied.From.Address == "" {
+		copied.From = fromAddress(config, msg.From.Name)
+	}
+	if m.RewriteRecipient != nil || m.BeforeSend != nil {
+		// 复制头部，避免改写或BeforeSend修改调用方的邮件
+		copied.Header = make(textproto.MIMEHeader, len(msg.Header)+1)
+		for key, values := range msg.Header {
+			copied.Header[key] = append([]string(nil), values...)
+		}
+	}
+	if m.RewriteRecipient != nil {
+		setOriginalTo(&copied, envelopeOf(msg), addrs)
+	}
+	var errs []error
+	if m.BeforeSend != nil {
+		allowed := addrs[:0:0]
+		for _, addr := range addrs {
+			if err := m.BeforeSend(ctx, addr, &copied); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr.Address, err))
+				continue
+			}
+			allowed = append(allowed, addr)
+		}
+		if addrs = allowed; len(addrs) == 0 {
+			result.Err = errors.Join(errs...)
+			return
+		}
+	}
+	message, err := m.prepare(&copied, addrs)
+	if err == nil {
+		err = m.checkFrom(config, copied.From)
+	}
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	recipients := make([]SendResult, len(addrs))
+	batch := make([]*SendResult, len(addrs))
+	for i, addr := range addrs {
+		recipients[i] = SendResult{Recipient: addr, Alias: m.isAlias(addr.Address)}
+		batch[i] = &recipients[i]
+	}
+	m.deliver(smtpClient, config, copied.From.Address, batch, message, true, false)
+
+	for _, recipient := range recipients {
+		if recipient.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", recipient.Recipient.Address, recipient.Err))
+		}
+	}
+	result.Response = recipients[0].Response
+	result.Size = len(message)
+	result.Host, result.Port = config.Host, config.Port
+	result.Err = errors.Join(errs...)
+}
+
+// checkRecipients 检查邮件的所有信封收件人，任一收件人的域名不被允许时整封邮件不发送
+func (m *Email) checkRecipients(addrs []mail.Address) error {
+	for _, addr := range addrs {
+		if err := m.checkRecipient(addr.Address); err != nil {
+			return fmt.Errorf("%s: %w", addr.Address, err)
+		}
+	}
+	return nil
+}
+
+// envelopeOf 返回邮件的信封收件人：Envelope非空时使用Envelope，否则为To、Cc和Bcc
+func envelopeOf(msg *Message) []mail.Address {
+	if len(msg.Envelope) > 0 {
+		return msg.Envelope
+	}
+	return append(append(append([]mail.Address(nil), msg.To...), msg.Cc...), msg.Bcc...)
+}
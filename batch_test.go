@@ -0,0 +1,200 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEmail_BatchSend tests grouping independent messages by relay and sending them on one connection per relay
+func TestEmail_BatchSend(t *testing.T) {
+	primary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	secondary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	m := New(map[string]*ConfigMapper{
+		"default":     primary.config(false),
+		"example.org": secondary.config(false),
+	})
+
+	msgs := []*Message{
+		{To: []mail.Address{{Address: "a@example.com"}}, Subject: "first", Body: "one"},
+		{To: []mail.Address{{Address: "b@example.org"}}, Subject: "second", Body: "two"},
+		{To: []mail.Address{{Address: "c@example.com"}}, Subject: "third", Body: "three"},
+		{Subject: "empty"},
+	}
+	results := m.BatchSend(context.Background(), msgs)
+	if len(results) != len(msgs) {
+		t.Fatalf("expected %d results, got %d", len(msgs), len(results))
+	}
+	for i, result := range results[:3] {
+		if result.Err != nil {
+			t.Errorf("message %d: unexpected error: %v", i, result.Err)
+		}
+		if result.Recipient.Address != msgs[i].To[0].Address {
+			t.Errorf("message %d: unexpected recipient %s", i, result.Recipient.Address)
+		}
+	}
+	if results[3].Err == nil {
+		t.Error("expected error for message without recipients")
+	}
+
+	if got := primary.connections(); got != 1 {
+		t.Errorf("expected 1 connection to primary relay, got %d", got)
+	}
+	if got := secondary.connections(); got != 1 {
+		t.Errorf("expected 1 connection to secondary relay, got %d", got)
+	}
+	if got := len(primary.received()); got != 2 {
+		t.Errorf("expected 2 messages on primary relay, got %d", got)
+	}
+	received := secondary.received()
+	if len(received) != 1 || !strings.Contains(received[0], "Subject: second") {
+		t.Errorf("unexpected messages on secondary relay: %q", received)
+	}
+	if !strings.Contains(received[0], "From: <sender@example.com>") {
+		t.Errorf("expected From to default to the relay username, got %q", received[0])
+	}
+	if msgs[1].From.Address != "" {
+		t.Error("BatchSend must not modify the caller's message")
+	}
+}
+
+// TestEmail_BatchSendCanceled tests that a canceled ctx prevents any connection
+func TestEmail_BatchSendCanceled(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	m := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := m.BatchSend(ctx, []*Message{{To: []mail.Address{{Address: "a@example.com"}}}})
+	if !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", results[0].Err)
+	}
+	if got := server.connections(); got != 0 {
+		t.Errorf("expected no connection after cancel, got %d", got)
+	}
+}
+
+// TestEmail_BatchSendDeadline tests that messages not started before the deadline fail with ErrNotAttempted
+func TestEmail_BatchSendDeadline(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if cmd == "." {
+				time.Sleep(200 * time.Millisecond)
+			}
+			return ""
+		},
+	}).start(t)
+	m := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	var msgs []*Message
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &Message{To: []mail.Address{{Address: fmt.Sprintf("user%d@example.com", i)}}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	results := m.BatchSend(ctx, msgs)
+
+	if results[0].Err != nil {
+		t.Errorf("expected first message to be delivered, got %v", results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("expected in-flight message to fail at the deadline")
+	}
+	for i, result := range results[2:] {
+		if !errors.Is(result.Err, ErrNotAttempted) || !errors.Is(result.Err, context.DeadlineExceeded) {
+			t.Errorf("message %d: expected not-attempted deadline error, got %v", i+2, result.Err)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected 1 connection, got %d", got)
+	}
+
+	// 连接应以QUIT结束
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		cmds := verbs(server.commands())
+		if cmds[len(cmds)-1] == "QUIT" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("expected connection to end with QUIT, got %q", verbs(server.commands()))
+}
+
+// TestEmail_BatchSendPriority tests that messages are delivered in Priority order
+func TestEmail_BatchSendPriority(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(cmd string) string {
+		if address, ok := strings.CutPrefix(cmd, "RCPT TO:<"); ok {
+			mu.Lock()
+			order = append(order, strings.TrimSuffix(address, ">"))
+			mu.Unlock()
+		}
+		return ""
+	}
+	primary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, reply: record}).start(t)
+	secondary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, reply: record}).start(t)
+	m := New(map[string]*ConfigMapper{
+		"default":     primary.config(false),
+		"example.org": secondary.config(false),
+	})
+	m.MaxConcurrency = 1
+
+	msgs := []*Message{
+		{To: []mail.Address{{Address: "low@example.com"}}, Subject: "low"},
+		{To: []mail.Address{{Address: "normal@example.com"}}, Subject: "normal", Priority: 5},
+		{To: []mail.Address{{Address: "urgent@example.org"}}, Subject: "urgent", Priority: 20},
+		{To: []mail.Address{{Address: "high@example.com"}}, Subject: "high", Priority: 10},
+		{To: []mail.Address{{Address: "later@example.org"}}, Subject: "later"},
+	}
+	for i, result := range m.BatchSend(context.Background(), msgs) {
+		if result.Err != nil {
+			t.Fatalf("message %d: unexpected error: %v", i, result.Err)
+		}
+		if result.Recipient.Address != msgs[i].To[0].Address {
+			t.Errorf("message %d: expected results in input order, got %s", i, result.Recipient.Address)
+		}
+	}
+	want := []string{"urgent@example.org", "later@example.org", "high@example.com", "normal@example.com", "low@example.com"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("expected delivery order %v, got %v", want, order)
+	}
+}
+
+// TestEmail_BatchSendRouting tests that BatchSend follows StrictRouting and PreflightRouting like Send
+func TestEmail_BatchSendRouting(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		configure func(*Email)
+		sent      int
+		want      string
+	}{
+		{"strict", func(m *Email) { m.StrictRouting = true }, 1, `no route for domain "unknown.com"`},
+		{"preflight", func(m *Email) { m.PreflightRouting = true }, 0, "unroutable recipients: user@unknown.com"},
+	} {
+		server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+		m := New(map[string]*ConfigMapper{"example.com": server.config(false)})
+		tc.configure(m)
+
+		results := m.BatchSend(context.Background(), []*Message{
+			{To: []mail.Address{{Address: "user@example.com"}}, Subject: "first", Body: "one"},
+			{To: []mail.Address{{Address: "user@unknown.com"}}, Subject: "second", Body: "two"},
+		})
+		if err := results[1].Err; err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected %q, got %v", tc.name, tc.want, err)
+		}
+		if tc.sent == 0 && results[0].Err == nil {
+			t.Errorf("%s: expected the routable message to be held back", tc.name)
+		}
+		if got := len(server.received()); got != tc.sent {
+			t.Errorf("%s: expected %d messages, got %d", tc.name, tc.sent, got)
+		}
+	}
+}
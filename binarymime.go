@@ -0,0 +1,101 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"strings"
+)
+
+// supportsBinaryMIME 判断服务器是否同时声明BINARYMIME和CHUNKING扩展（RFC 3030）
+// BINARYMIME只能通过BDAT传输，缺少CHUNKING时不可用
+func supportsBinaryMIME(smtpClient *smtp.Client) bool {
+	binary, _ := smtpClient.Extension("BINARYMIME")
+	chunking, _ := smtpClient.Extension("CHUNKING")
+	return binary && chunking
+}
+
+// binaryMessage 将邮件中base64编码的附件还原为原始字节，Content-Transfer-Encoding改为binary
+// 只处理顶层multipart/mixed中的附件部分，邮件头、边界和其他部分保持不变；
+// 邮件不包含base64附件或无法解析时返回原内容和false
+func binaryMessage(message []byte) ([]byte, bool) {
+	end := bytes.Index(message, []byte("\r\n\r\n"))
+	if end < 0 {
+		return message, false
+	}
+	header, body := message[:end+4], message[end+4:]
+	var boundary string
+	for line := range strings.SplitSeq(string(header), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil || mediaType != "multipart/mixed" {
+			return message, false
+		}
+		boundary = params["boundary"]
+	}
+	if boundary == "" {
+		return message, false
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	mw := multipart.NewWriter(&out)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return message, false
+	}
+	converted := false
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return message, false
+		}
+		partHeader := part.Header
+		var content io.Reader = part
+		disposition, _, _ := mime.ParseMediaType(partHeader.Get("Content-Disposition"))
+		if disposition == "attachment" && strings.EqualFold(partHeader.Get("Content-Transfer-Encoding"), EncodingBase64) {
+			partHeader.Set("Content-Transfer-Encoding", "binary")
+			content = base64.NewDecoder(base64.StdEncoding, part)
+			converted = true
+		}
+		w, err := mw.CreatePart(partHeader)
+		if err != nil {
+			return message, false
+		}
+		if _, err = io.Copy(w, content); err != nil {
+			return message, false
+		}
+	}
+	if !converted || mw.Close() != nil {
+		return message, false
+	}
+	return out.Bytes(), true
+}
+
+// bdat 使用BDAT命令（RFC 3030）一次性发送整封邮件，内容按原样传输，不做点转义和换行转换
+func bdat(smtpClient *smtp.Client, config *ConfigMapper, message []byte) error {
+	text := smtpClient.Text
+	id, err := text.Cmd("BDAT %d LAST", len(message))
+	if err != nil {
+		return err
+	}
+	if _, err = text.W.Write(message); err != nil {
+		return err
+	}
+	if err = text.W.Flush(); err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, _, err = readReply(text, config)
+	return err
+}
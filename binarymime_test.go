@@ -0,0 +1,73 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEmail_BinaryMIME tests sending base64 attachments as raw bytes with BDAT when the server supports BINARYMIME
+func TestEmail_BinaryMIME(t *testing.T) {
+	// 包含CRLF、单独的点和8位字节，DATA传输时需要转义，BDAT按原样发送
+	data := []byte("\x00\x01\r\n.\r\nbinary\xff\xfe")
+	msg := &Message{
+		From:        mail.Address{Address: "sender@example.com"},
+		To:          []mail.Address{{Address: "user@example.com"}},
+		Subject:     "附件",
+		Body:        "正文",
+		Attachments: []*Attachment{NewAttachment("data.bin", data)},
+	}
+
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME", "PIPELINING", "BINARYMIME", "CHUNKING", "SIZE 1000000"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.BinaryMIME = true
+	if results := email.BatchSend(context.Background(), []*Message{msg}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	cmds := server.commands()
+	if !slices.Contains(verbs(cmds), "BDAT") || slices.Contains(verbs(cmds), "DATA") {
+		t.Errorf("expected BDAT instead of DATA, got %v", cmds)
+	}
+	var bdat string
+	for _, cmd := range cmds {
+		if strings.HasPrefix(cmd, "MAIL FROM:") && !strings.Contains(cmd, "BODY=BINARYMIME") {
+			t.Errorf("expected BODY=BINARYMIME, got %q", cmd)
+		}
+		if strings.HasPrefix(cmd, "BDAT ") {
+			bdat = cmd
+		}
+	}
+	received := server.received()[0]
+	if want := "BDAT " + strconv.Itoa(len(received)) + " LAST"; bdat != want {
+		t.Errorf("expected %q, got %q", want, bdat)
+	}
+	if !strings.Contains(received, "Content-Transfer-Encoding: binary") {
+		t.Errorf("expected binary attachment:\n%s", received)
+	}
+	if !strings.Contains(received, string(data)) {
+		t.Errorf("expected raw attachment bytes:\n%q", received)
+	}
+
+	// 服务器不支持BINARYMIME时按base64通过DATA发送
+	server = (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME", "CHUNKING"}}).start(t)
+	email = New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.BinaryMIME = true
+	if results := email.BatchSend(context.Background(), []*Message{msg}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	if slices.Contains(verbs(server.commands()), "BDAT") {
+		t.Errorf("expected DATA fallback, got %v", server.commands())
+	}
+	received = server.received()[0]
+	if !strings.Contains(received, "Content-Transfer-Encoding: base64") || !strings.Contains(received, base64.StdEncoding.EncodeToString(data)) {
+		t.Errorf("expected base64 attachment:\n%s", received)
+	}
+	if bytes.Contains([]byte(received), data) {
+		t.Errorf("expected no raw attachment bytes in DATA fallback")
+	}
+}
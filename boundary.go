@@ -0,0 +1,59 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"slices"
+)
+
+// maxBoundaryAttempts 边界与内容冲突时重新生成边界的最大次数
+const maxBoundaryAttempts = 10
+
+// newBoundary 从random读取30字节生成边界，与标准库默认的边界格式相同；random为nil时使用crypto/rand
+func newBoundary(random io.Reader) (string, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	var buf [30]byte
+	if _, err := io.ReadFull(random, buf[:]); err != nil {
+		return "", fmt.Errorf("email: failed to generate boundary: %w", err)
+	}
+	return fmt.Sprintf("%x", buf[:]), nil
+}
+
+// writeMultipart 将parts依次写为multipart内容，返回使用的边界和内容
+// 边界出现在任一部分已编码的内容中时重新生成，超过maxBoundaryAttempts次仍冲突时返回错误
+func writeMultipart(parts []MIMEPart, random io.Reader) (string, []byte, error) {
+	for range maxBoundaryAttempts {
+		boundary, err := newBoundary(random)
+		if err != nil {
+			return "", nil, err
+		}
+		if slices.ContainsFunc(parts, func(part MIMEPart) bool { return bytes.Contains(part.Body, []byte(boundary)) }) {
+			continue
+		}
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		if err = mw.SetBoundary(boundary); err != nil {
+			return "", nil, err
+		}
+		for _, part := range parts {
+			w, err := mw.CreatePart(part.Header)
+			if err != nil {
+				return "", nil, err
+			}
+			if _, err = w.Write(part.Body); err != nil {
+				return "", nil, err
+			}
+		}
+		if err = mw.Close(); err != nil {
+			return "", nil, err
+		}
+		return boundary, buf.Bytes(), nil
+	}
+	return "", nil, errors.New("email: failed to generate a boundary that does not appear in the content")
+}
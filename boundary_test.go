@@ -0,0 +1,55 @@
+package email
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// TestWriteMultipart tests the exact multipart structure produced with a fixed random source
+func TestWriteMultipart(t *testing.T) {
+	parts := []MIMEPart{
+		{Header: textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}}, Body: []byte("正文")},
+		{Header: textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}}, Body: []byte("AAEC")},
+	}
+	boundary, content, err := writeMultipart(parts, bytes.NewReader(bytes.Repeat([]byte{0xab}, 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("ab", 30); boundary != want {
+		t.Fatalf("expected boundary %s, got %s", want, boundary)
+	}
+	want := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		"正文\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/octet-stream\r\n\r\n" +
+		"AAEC\r\n" +
+		"--" + boundary + "--\r\n"
+	if string(content) != want {
+		t.Errorf("unexpected multipart content:\n got %q\nwant %q", content, want)
+	}
+
+	if _, _, err := writeMultipart(parts, strings.NewReader("short")); err == nil {
+		t.Error("expected error when the random source is exhausted")
+	}
+}
+
+// TestWriteMultipart_Collision tests that a boundary appearing in the content is regenerated
+func TestWriteMultipart_Collision(t *testing.T) {
+	first := bytes.Repeat([]byte{0x11}, 30)
+	second := bytes.Repeat([]byte{0x22}, 30)
+	parts := []MIMEPart{{Header: textproto.MIMEHeader{}, Body: []byte("--" + strings.Repeat("11", 30))}}
+	boundary, _, err := writeMultipart(parts, bytes.NewReader(append(first, second...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boundary != strings.Repeat("22", 30) {
+		t.Errorf("expected the colliding boundary to be regenerated, got %s", boundary)
+	}
+
+	if _, _, err := writeMultipart(parts, bytes.NewReader(bytes.Repeat(first, maxBoundaryAttempts))); err == nil {
+		t.Error("expected error when every boundary collides")
+	}
+}
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+)
+
+// MessageBuilder 以链式调用构造Message，所有检查集中在Build时进行
+//
+//	msg, err := email.NewMessage().
+//		From("系统通知 <noreply@example.com>").
+//		To("user@example.com").
+//		Subject("订单已发货").
+//		HTML("<p>您的订单已发货</p>").
+//		Text("您的订单已发货").
+//		Build()
+type MessageBuilder struct {
+	msg  Message
+	from string
+	html string
+	text string
+	errs []error
+}
+
+// NewMessage 创建邮件构造器
+func NewMessage() *MessageBuilder {
+	return &MessageBuilder{msg: Message{Header: make(textproto.MIMEHeader)}}
+}
+
+// From 设置发件人，raw可以是"名称 <地址>"或裸地址
+func (b *MessageBuilder) From(raw string) *MessageBuilder {
+	b.from = raw
+	return b
+}
+
+// To 添加收件人
+func (b *MessageBuilder) To(raw ...string) *MessageBuilder {
+	b.add(b.msg.To.Add(raw...))
+	return b
+}
+
+// Cc 添加抄送收件人
+func (b *MessageBuilder) Cc(raw ...string) *MessageBuilder {
+	b.add(b.msg.Cc.Add(raw...))
+	return b
+}
+
+// Bcc 添加密送收件人
+func (b *MessageBuilder) Bcc(raw ...string) *MessageBuilder {
+	b.add(b.msg.Bcc.Add(raw...))
+	return b
+}
+
+// ReplyTo 添加Reply-To地址
+func (b *MessageBuilder) ReplyTo(raw ...string) *MessageBuilder {
+	b.add(b.msg.ReplyTo.Add(raw...))
+	return b
+}
+
+// Subject 设置主题
+func (b *MessageBuilder) Subject(subject string) *MessageBuilder {
+	b.msg.Subject = subject
+	return b
+}
+
+// HTML 设置HTML正文，同时设置了Text时Text作为纯文本替代内容
+func (b *MessageBuilder) HTML(body string) *MessageBuilder {
+	b.html = body
+	return b
+}
+
+// Text 设置纯文本正文，同时设置了HTML时作为HTML的纯文本替代内容
+func (b *MessageBuilder) Text(body string) *MessageBuilder {
+	b.text = body
+	return b
+}
+
+// Attach 添加附件
+func (b *MessageBuilder) Attach(attachments ...*Attachment) *MessageBuilder {
+	b.msg.Attachments = append(b.msg.Attachments, attachments...)
+	return b
+}
+
+// Header 添加额外的邮件头，同名的邮件头可以添加多次
+func (b *MessageBuilder) Header(key, value string) *MessageBuilder {
+	b.msg.Header.Add(key, value)
+	return b
+}
+
+// add 记录构造过程中的错误，在Build时返回
+func (b *MessageBuilder) add(err error) {
+	if err != nil {
+		b.errs = append(b.errs, err)
+	}
+}
+
+// Build 检查并返回构造的邮件：发件人、收件人和主题不能为空，地址必须有效，
+// 邮件头不能包含换行（主题中的换行在发送时检查）；返回的错误汇总所有发现的问题
+func (b *MessageBuilder) Build() (*Message, error) {
+	errs := append([]error(nil), b.errs...)
+	msg := b.msg
+	msg.Header = make(textproto.MIMEHeader, len(b.msg.Header))
+	for key, values := range b.msg.Header {
+		msg.Header[key] = append([]string(nil), values...)
+	}
+	msg.To = append(AddressList(nil), b.msg.To...)
+	msg.Cc = append(AddressList(nil), b.msg.Cc...)
+	msg.Bcc = append(AddressList(nil), b.msg.Bcc...)
+	msg.ReplyTo = append(AddressList(nil), b.msg.ReplyTo...)
+	msg.Attachments = append([]*Attachment(nil), b.msg.Attachments...)
+
+	if b.from == "" {
+		errs = append(errs, errors.New("email: message has no sender"))
+	} else if from, err := mail.ParseAddress(b.from); err != nil {
+		errs = append(errs, fmt.Errorf("email: invalid address %q: %w", b.from, err))
+	} else {
+		msg.From = *from
+	}
+	if len(msg.To)+len(msg.Cc)+len(msg.Bcc) == 0 {
+		errs = append(errs, ErrNoRecipients)
+	}
+	if msg.Subject == "" {
+		errs = append(errs, errors.New("email: message has no subject"))
+	}
+	if b.html != "" {
+		msg.Body, msg.HTML, msg.Text = b.html, true, b.text
+	} else {
+		msg.Body = b.text
+	}
+	if len(errs) == 0 {
+		// 序列化并重新解析，发现邮件头注入等结构问题
+		if err := msg.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestMessageBuilder tests building a message with every builder method
+func TestMessageBuilder(t *testing.T) {
+	msg, err := NewMessage().
+		From("系统通知 <noreply@example.com>").
+		To("user@example.com", "Other <other@example.com>").
+		Cc("cc@example.com").
+		Bcc("audit@example.com").
+		ReplyTo("support@example.com").
+		Subject("订单已发货").
+		HTML("<p>您的订单已发货</p>").
+		Text("您的订单已发货").
+		Attach(NewAttachment("invoice.pdf", []byte("%PDF-1.4"))).
+		Header("X-Campaign", "shipping").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.From.Name != "系统通知" || msg.From.Address != "noreply@example.com" {
+		t.Errorf("unexpected From %v", msg.From)
+	}
+	if len(msg.To) != 2 || msg.To[1].Name != "Other" || len(msg.Cc) != 1 || len(msg.Bcc) != 1 || len(msg.ReplyTo) != 1 {
+		t.Errorf("unexpected recipients: To=%v Cc=%v Bcc=%v ReplyTo=%v", msg.To, msg.Cc, msg.Bcc, msg.ReplyTo)
+	}
+	if !msg.HTML || msg.Body != "<p>您的订单已发货</p>" || msg.Text != "您的订单已发货" {
+		t.Errorf("unexpected body: HTML=%v Body=%q Text=%q", msg.HTML, msg.Body, msg.Text)
+	}
+	if len(msg.Attachments) != 1 || msg.Header.Get("X-Campaign") != "shipping" {
+		t.Errorf("unexpected attachments %v or header %v", msg.Attachments, msg.Header)
+	}
+}
+
+// TestMessageBuilder_Validation tests that Build reports all invalid fields together
+func TestMessageBuilder_Validation(t *testing.T) {
+	_, err := NewMessage().
+		From("noreply@example.com").
+		To("user@example.com", "not an address").
+		Text("内容").
+		Build()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"no subject", `invalid address "not an address"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	if _, err = NewMessage().From("noreply@example.com").Subject("主题").Text("内容").Build(); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("expected ErrNoRecipients, got %v", err)
+	}
+	_, err = NewMessage().From("noreply@example.com").To("user@example.com").Subject("主题").
+		Header("X-Note", "a\r\nBcc: victim@example.com").Build()
+	if err == nil || !strings.Contains(err.Error(), "invalid value for header X-Note") {
+		t.Errorf("expected header injection error, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// DANE验证方式（RFC 7672）
+const (
+	DANENone          = ""              // 不使用DANE
+	DANEOpportunistic = "opportunistic" // 存在可用的TLSA记录时必须加密并按记录验证证书，没有记录时按普通方式连接
+	DANEMandatory     = "mandatory"     // 必须存在可用的TLSA记录并验证通过，否则不发送
+)
+
+// TLSA记录的证书用途，SMTP只使用DANE-TA和DANE-EE（RFC 7672 3.1节）
+const (
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+)
+
+// TLSARecord TLSA记录（RFC 6698）
+type TLSARecord struct {
+	Usage        uint8 // 证书用途：2为DANE-TA（信任锚），3为DANE-EE（服务器证书）
+	Selector     uint8 // 0为完整证书，1为公钥（SubjectPublicKeyInfo）
+	MatchingType uint8 // 0为原始内容，1为SHA-256，2为SHA-512
+	Data         []byte
+}
+
+// TLSAResolver 查询TLSA记录，实现者负责DNSSEC验证，只应返回经过验证的记录
+// name的格式为_25._tcp.mx.example.com，没有记录时返回nil和nil
+type TLSAResolver interface {
+	LookupTLSA(ctx context.Context, name string) ([]TLSARecord, error)
+}
+
+// daneRecords 查询配置的服务器可用的TLSA记录，未开启DANE或没有记录时返回nil
+// 查询失败时两种方式都返回错误，DANEMandatory下没有可用记录也返回错误
+func daneRecords(ctx context.Context, config *ConfigMapper) ([]TLSARecord, error) {
+	if config.DANE == DANENone {
+		return nil, nil
+	}
+	if config.TLSAResolver == nil {
+		return nil, errors.New("email: DANE requires a TLSAResolver")
+	}
+	name := fmt.Sprintf("_%d._tcp.%s", config.Port, config.Host)
+	records, err := config.TLSAResolver.LookupTLSA(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("email: TLSA lookup for %s failed: %w", name, err)
+	}
+	usable := records[:0:0]
+	for _, record := range records {
+		if (record.Usage == tlsaUsageDANETA || record.Usage == tlsaUsageDANEEE) && record.Selector <= 1 && record.MatchingType <= 2 {
+			usable = append(usable, record)
+		}
+	}
+	if len(usable) == 0 && config.DANE == DANEMandatory {
+		return nil, fmt.Errorf("email: DANE required but no usable TLSA records for %s", name)
+	}
+	return usable, nil
+}
+
+// daneTLSConfig 生成TLS配置，records非空时按TLSA记录验证服务器证书而不是PKIX验证
+// DANE-EE记录只比较服务器证书，不检查名称和有效期（RFC 7672 3.1.1节）；
+// DANE-TA记录要求服务器证书链接到匹配的信任锚，并检查名称和有效期（RFC 7672 3.1.2节、3.2节）
+func daneTLSConfig(config *ConfigMapper, records []TLSARecord) *tls.Config {
+	tlsConfig := newTLSConfig(config)
+	if len(records) == 0 {
+		return tlsConfig
+	}
+	name := tlsConfig.ServerName
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		for _, record := range records {
+			if verifyTLSA(record, state.PeerCertificates, name) {
+				return nil
+			}
+		}
+		return errors.New("email: DANE verification failed: no TLSA record matches the server certificate")
+	}
+	return tlsConfig
+}
+
+// verifyTLSA 按TLSA记录验证证书链：DANE-EE只比较服务器证书；
+// DANE-TA在服务器证书之后的证书中查找信任锚，服务器证书本身不能作为信任锚，
+// 找到后验证服务器证书经由链中其余证书链接到该信任锚，且名称与name匹配
+func verifyTLSA(record TLSARecord, certs []*x509.Certificate, name string) bool {
+	if len(certs) == 0 {
+		return false
+	}
+	if record.Usage == tlsaUsageDANEEE {
+		return matchTLSA(record, certs[0])
+	}
+	for i, anchor := range certs[1:] {
+		if !matchTLSA(record, anchor) {
+			continue
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(anchor)
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1 : i+1] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{DNSName: name, Roots: roots, Intermediates: intermediates})
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTLSA 判断证书与TLSA记录的数据是否匹配
+func matchTLSA(record TLSARecord, cert *x509.Certificate) bool {
+	data := cert.Raw
+	if record.Selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch record.MatchingType {
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	}
+	return bytes.Equal(data, record.Data)
+}
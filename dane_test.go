@@ -0,0 +1,197 @@
+package email
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTLSAResolver 返回固定TLSA记录的解析器，记录查询的名称
+type fakeTLSAResolver struct {
+	records []TLSARecord
+	names   []string
+}
+
+func (r *fakeTLSAResolver) LookupTLSA(_ context.Context, name string) ([]TLSARecord, error) {
+	r.names = append(r.names, name)
+	return r.records, nil
+}
+
+// TestEmail_DANE tests verifying the server certificate against TLSA records
+func TestEmail_DANE(t *testing.T) {
+	cert, err := x509.ParseCertificate(mockCertificate(t).Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	for _, tt := range []struct {
+		name   string
+		record TLSARecord
+		ok     bool
+	}{
+		{"matching DANE-EE SPKI SHA-256", TLSARecord{Usage: 3, Selector: 1, MatchingType: 1, Data: spki[:]}, true},
+		{"matching DANE-EE full certificate", TLSARecord{Usage: 3, Selector: 0, MatchingType: 0, Data: cert.Raw}, true},
+		{"mismatching certificate", TLSARecord{Usage: 3, Selector: 1, MatchingType: 1, Data: make([]byte, sha256.Size)}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+			resolver := &fakeTLSAResolver{records: []TLSARecord{tt.record}}
+			config := server.config(false)
+			config.DANE = DANEMandatory
+			config.TLSAResolver = resolver
+
+			email := New(map[string]*ConfigMapper{"default": config})
+			results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "subject", "content", SendOptions{})
+			if want := "_" + strings.Split(server.ln.Addr().String(), ":")[1] + "._tcp.127.0.0.1"; len(resolver.names) != 1 || resolver.names[0] != want {
+				t.Errorf("expected TLSA lookup for %s, got %v", want, resolver.names)
+			}
+			if tt.ok {
+				if results[0].Err != nil {
+					t.Fatalf("expected DANE verification to succeed, got %v", results[0].Err)
+				}
+				if server.tlsUpgrades() != 1 {
+					t.Errorf("expected STARTTLS, got %d upgrades", server.tlsUpgrades())
+				}
+				return
+			}
+			if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "DANE verification failed") {
+				t.Errorf("expected DANE verification failure, got %v", results[0].Err)
+			}
+			if len(server.received()) != 0 {
+				t.Error("expected no message to be sent")
+			}
+		})
+	}
+}
+
+// daneChain 生成测试用的信任锚和由其签发的服务器证书，同时生成一个自签名的伪造服务器证书
+// 返回的证书链均为服务器证书在前、信任锚在后
+func daneChain(t *testing.T) (anchor *x509.Certificate, genuine, forged tls.Certificate) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	create := func(tmpl, parent *x509.Certificate, key, signer *ecdsa.PrivateKey) []byte {
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	leaf := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+
+	caKey := newKey()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(10),
+		Subject:               pkix.Name{CommonName: "Relay TA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER := create(caTmpl, caTmpl, caKey, caKey)
+	anchor, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey := newKey()
+	genuine = tls.Certificate{Certificate: [][]byte{create(leaf(11), anchor, leafKey, caKey), caDER}, PrivateKey: leafKey}
+	forgedKey := newKey()
+	forgedTmpl := leaf(12)
+	forged = tls.Certificate{Certificate: [][]byte{create(forgedTmpl, forgedTmpl, forgedKey, forgedKey), caDER}, PrivateKey: forgedKey}
+	return anchor, genuine, forged
+}
+
+// TestEmail_DANETrustAnchor tests that DANE-TA requires the server certificate to chain to the matching anchor
+func TestEmail_DANETrustAnchor(t *testing.T) {
+	anchor, genuine, forged := daneChain(t)
+	spki := sha256.Sum256(anchor.RawSubjectPublicKeyInfo)
+	leafHash := func(cert tls.Certificate) []byte {
+		sum := sha256.Sum256(cert.Certificate[0])
+		return sum[:]
+	}
+
+	for _, tt := range []struct {
+		name   string
+		chain  tls.Certificate
+		record TLSARecord
+		ok     bool
+	}{
+		{"chain to anchor", genuine, TLSARecord{Usage: 2, Selector: 1, MatchingType: 1, Data: spki[:]}, true},
+		{"forged leaf with genuine anchor", forged, TLSARecord{Usage: 2, Selector: 1, MatchingType: 1, Data: spki[:]}, false},
+		{"leaf is not a trust anchor", genuine, TLSARecord{Usage: 2, Selector: 0, MatchingType: 1, Data: leafHash(genuine)}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: []string{"AUTH LOGIN PLAIN"}, certificate: &tt.chain}).start(t)
+			config := server.config(false)
+			config.DANE = DANEMandatory
+			config.TLSAResolver = &fakeTLSAResolver{records: []TLSARecord{tt.record}}
+
+			email := New(map[string]*ConfigMapper{"default": config})
+			results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "subject", "content", SendOptions{})
+			if tt.ok {
+				if results[0].Err != nil {
+					t.Fatalf("expected DANE verification to succeed, got %v", results[0].Err)
+				}
+				return
+			}
+			if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "DANE verification failed") {
+				t.Errorf("expected DANE verification failure, got %v", results[0].Err)
+			}
+			if len(server.received()) != 0 {
+				t.Error("expected no message to be sent")
+			}
+		})
+	}
+}
+
+// TestEmail_DANEMandatoryWithoutRecords tests DANEMandatory and DANEOpportunistic when no TLSA records exist
+func TestEmail_DANEMandatoryWithoutRecords(t *testing.T) {
+	server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.DANE = DANEMandatory
+	config.TLSAResolver = &fakeTLSAResolver{}
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "no usable TLSA records") {
+		t.Errorf("expected missing TLSA records error, got %v", results[0].Err)
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connections, got %d", server.connections())
+	}
+
+	// 没有记录时opportunistic按普通方式发送
+	config.DANE = DANEOpportunistic
+	results = email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err == nil || !errors.Is(results[0].Err, ErrUnencrypted) {
+		t.Errorf("expected plain connection without DANE records, got %v", results[0].Err)
+	}
+}
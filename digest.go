@@ -0,0 +1,92 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// DigestData 渲染汇总邮件时传给模板的数据
+type DigestData struct {
+	Recipient mail.Address
+	Items     []any // 按Add的顺序排列
+}
+
+// Digest 按收件人累积通知内容，Flush时每个收件人只收到一封汇总邮件
+// 可以被多个goroutine同时使用
+type Digest struct {
+	email     *Email
+	fromName  string
+	templates *templates
+	opts      SendOptions
+
+	mu      sync.Mutex
+	order   []string // 收件人首次加入的顺序
+	pending map[string]*DigestData
+}
+
+// NewDigest 创建汇总邮件聚合器，主题和正文模板以DigestData渲染，模板规则与SendTemplate相同
+func (m *Email) NewDigest(fromName, subjectTmpl, bodyTmpl string, isHTML ...bool) (*Digest, error) {
+	opts := m.optionsOf(isHTML)
+	t, err := m.parseTemplates(subjectTmpl, bodyTmpl, opts.HTML)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{
+		email:     m,
+		fromName:  fromName,
+		templates: t,
+		opts:      opts,
+		pending:   make(map[string]*DigestData),
+	}, nil
+}
+
+// Add 为收件人累积一条内容，地址不区分大小写，收件人名称使用首次加入时的名称
+func (d *Digest) Add(recipient mail.Address, item any) {
+	key := strings.ToLower(recipient.Address)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.pending[key]
+	if !ok {
+		data = &DigestData{Recipient: recipient}
+		d.pending[key] = data
+		d.order = append(d.order, key)
+	}
+	data.Items = append(data.Items, item)
+}
+
+// Len 返回等待发送汇总邮件的收件人数
+func (d *Digest) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.order)
+}
+
+// Flush 为每个有累积内容的收件人渲染并发送一封汇总邮件，返回按收件人首次加入顺序排列的结果
+// 发送后清空累积的内容，发送失败的内容不会保留；ctx已取消时不发送，内容保留到下次Flush
+func (d *Digest) Flush(ctx context.Context) []SendResult {
+	d.mu.Lock()
+	if err := ctxErr(ctx); err != nil {
+		results := make([]SendResult, len(d.order))
+		for i, key := range d.order {
+			results[i] = SendResult{Recipient: d.pending[key].Recipient, Err: notAttempted(err)}
+		}
+		d.mu.Unlock()
+		return results
+	}
+	order, pending := d.order, d.pending
+	d.order, d.pending = nil, make(map[string]*DigestData)
+	d.mu.Unlock()
+
+	if len(order) == 0 {
+		return nil
+	}
+	toList := make([]mail.Address, len(order))
+	for i, key := range order {
+		toList[i] = pending[key].Recipient
+	}
+	return d.email.send(d.fromName, toList, func(to mail.Address) (string, string, error) {
+		return d.templates.render(pending[strings.ToLower(to.Address)])
+	}, d.opts, nil)
+}
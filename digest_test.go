@@ -0,0 +1,72 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestDigest_Flush tests grouping digest items per recipient and keeping them when Flush is not attempted
+func TestDigest_Flush(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	digest, err := email.NewDigest("通知", "您有{{len .Items}}条新通知",
+		"{{.Recipient.Name}}：{{range .Items}}\n- {{.}}{{end}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alice := mail.Address{Name: "alice", Address: "alice@example.com"}
+	digest.Add(alice, "订单已发货")
+	digest.Add(mail.Address{Address: "bob@example.com"}, "新评论")
+	digest.Add(mail.Address{Address: "Alice@Example.com"}, "订单已签收")
+	digest.Add(alice, "账单已生成")
+
+	// ctx已取消时不发送，内容保留
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := digest.Flush(ctx)
+	if len(results) != 2 || !errors.Is(results[0].Err, ErrNotAttempted) {
+		t.Fatalf("expected results not attempted, got %+v", results)
+	}
+	if digest.Len() != 2 || len(server.received()) != 0 {
+		t.Fatalf("expected pending items to be kept, got %d pending and %d sent", digest.Len(), len(server.received()))
+	}
+
+	results = digest.Flush(context.Background())
+	if len(results) != 2 || results[0].Recipient != alice {
+		t.Fatalf("expected one result per recipient in insertion order, got %+v", results)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("unexpected error for %s: %v", result.Recipient.Address, result.Err)
+		}
+	}
+	received := server.received()
+	if len(received) != 2 {
+		t.Fatalf("expected one digest per recipient, got %d messages", len(received))
+	}
+	var body string
+	for _, message := range received {
+		if headers, b := splitMessage(t, []byte(message)); strings.Contains(headers, "alice@example.com") {
+			decoded, _ := io.ReadAll(quotedprintable.NewReader(strings.NewReader(b)))
+			body = string(decoded)
+		}
+	}
+	for _, want := range []string{"alice：", "- 订单已发货", "- 订单已签收", "- 账单已生成"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in digest body:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "新评论") {
+		t.Errorf("expected bob's item only in bob's digest:\n%s", body)
+	}
+
+	if digest.Len() != 0 || digest.Flush(context.Background()) != nil {
+		t.Errorf("expected the digest to be empty after Flush")
+	}
+}
@@ -2,13 +2,22 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 type NotAuth struct {
@@ -27,11 +36,11 @@ func (a *NotAuth) Start(server *smtp.ServerInfo) (proto string, toServer []byte,
 			}
 		}
 		if !advertised {
-			return "", nil, errors.New("gomail: unencrypted connection")
+			return "", nil, fmt.Errorf("%w for LOGIN auth; set TLS or OpportunisticTLS", ErrUnencrypted)
 		}
 	}
 	//if server.Name != a.Host {
-	//    return "", nil, errors.New("gomail: wrong Host name")
+	//    return "", nil, errors.New("email: wrong Host name")
 	//}
 	return "LOGIN", nil, nil
 }
@@ -46,10 +55,34 @@ func (a *NotAuth) Next(fromServer []byte, more bool) (toServer []byte, err error
 	case bytes.Equal(fromServer, []byte("Password:")):
 		return []byte(a.Password), nil
 	default:
-		return nil, fmt.Errorf("gomail: unexpected server challenge: %s", fromServer)
+		return nil, fmt.Errorf("email: unexpected server challenge: %s", fromServer)
 	}
 }
 
+// 身份验证方式
+const (
+	AuthDefault = ""      // 普通SMTP使用LOGIN，TLS加密使用PLAIN
+	AuthLogin   = "LOGIN" // LOGIN认证
+	AuthPlain   = "PLAIN" // PLAIN认证
+	AuthNone    = "NONE"  // 不进行身份验证
+)
+
+// 结束SMTP会话的方式
+const (
+	QuitWait        = ""       // 发送QUIT并等待响应，Send和SendGroup将QUIT的错误以ErrQuitFailed记录到发送结果
+	QuitIgnoreError = "ignore" // 发送QUIT并等待响应，忽略错误
+	QuitSkip        = "skip"   // 不发送QUIT，直接关闭连接，减少一次往返
+)
+
+// EHLO/HELO名称的来源，HELOName非空时总是使用HELOName
+const (
+	HELODefault    = ""            // 使用localhost，开启HELOAddressLiteral时见其说明
+	HELOStatic     = "static"      // 使用HELOName，HELOName不能为空
+	HELOHostname   = "hostname"    // 使用os.Hostname()返回的本机主机名
+	HELOReverseDNS = "reverse-dns" // 使用出站IP地址反向DNS查询的第一个结果，与PTR记录保持一致
+	HELOLocalhost  = "localhost"   // 总是使用localhost
+)
+
 type ConfigMapper struct {
 	TLS           bool
 	Host          string
@@ -57,40 +90,251 @@ type ConfigMapper struct {
 	Username      string
 	Password      string
 	SkipTLSVerify bool // 是否跳过TLS证书验证，默认false
+	// TLSServerName 验证服务器证书使用的名称，为空时使用Host
+	TLSServerName string
+	// TLSMinVersion 允许的最低TLS版本，如tls.VersionTLS13，0表示TLS 1.2
+	TLSMinVersion uint16
+	// ClientCertFile、ClientKeyFile 双向TLS的客户端证书和私钥的PEM文件路径，TLS握手时向服务器出示，
+	// 可与SMTP AUTH同时使用，或配合AuthNone只使用证书认证
+	ClientCertFile string
+	ClientKeyFile  string
+	// ClientCertPEM、ClientKeyPEM PEM格式的客户端证书和私钥内容，非空时优先于ClientCertFile和ClientKeyFile
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+	// MaxRecipientsPerMessage 单次投递允许的最大收件人数，0表示不限制
+	MaxRecipientsPerMessage int
+	// FromName 默认发件人显示名称，调用Send时fromName为空则使用该值
+	FromName string
+	// OpportunisticTLS 普通SMTP连接下，服务器支持STARTTLS时在认证前升级为加密连接
+	OpportunisticTLS bool
+	// AuthStartTLS 普通SMTP连接下服务器未声明所需的认证方式时，若支持STARTTLS则先升级为加密连接再重新检查；
+	// AuthDefault在升级后服务器只声明PLAIN时使用PLAIN。已开启OpportunisticTLS时总是先升级，无需设置
+	AuthStartTLS bool
+	// HELOName EHLO/HELO命令使用的主机名，为空时使用标准库默认值localhost
+	HELOName string
+	// HELOStrategy HELOName为空时EHLO/HELO名称的来源，见HELOHostname等常量
+	HELOStrategy string
+	// HELOAddressLiteral HELOName为空且本机主机名不是可解析的完整域名时，
+	// 使用本机IP地址字面量（如[192.0.2.1]）代替localhost（RFC 5321 4.1.3节）
+	HELOAddressLiteral bool
+	// Timeout 连接建立及整个SMTP会话的超时时间，0表示不限制
+	Timeout time.Duration
+	// MaxResponseLineLength 服务器单行响应允许的最大字节数，超过时返回ErrResponseTooLong，0表示使用默认值8KB；
+	// 限制作用于明文连接的问候和之后所有命令的响应。隐式TLS（TLS=true）连接的问候和STARTTLS之后的首个EHLO响应
+	// 由net/smtp直接从*tls.Conn读取，无法在不丢失TLS状态的情况下包装，因此不受该限制；连接不可信的服务器时
+	// 应同时设置GreetingTimeout和CommandTimeout限制这两次读取的时间
+	MaxResponseLineLength int
+	// DANE 使用TLSA记录验证服务器证书（RFC 7672），见DANEOpportunistic等常量；存在可用的TLSA记录时必须加密传输
+	DANE string
+	// TLSAResolver 开启DANE时查询TLSA记录，应只返回经过DNSSEC验证的记录
+	TLSAResolver TLSAResolver
+	// CommandTimeout 每个命令发出后等待服务器完整响应（包括多行响应）的超时时间，不超过会话的截止时间；
+	// 0表示只受Timeout限制
+	CommandTimeout time.Duration
+	// GreetingTimeout 建立连接后等待服务器220问候的超时时间，用于尽快放弃延迟问候的服务器（tarpitting），
+	// 不影响之后的命令；0表示只受Timeout限制
+	GreetingTimeout time.Duration
+	// SuccessCode 判断MAIL FROM、RCPT TO和DATA结束时服务器的响应码是否表示成功，
+	// 用于使用非标准响应码的网关；为nil时任何2xx响应码都表示成功
+	SuccessCode func(code int) bool
+	// AuthType 身份验证方式，见AuthDefault等常量
+	AuthType string
+	// RequireTLS 要求全程加密传输（RFC 8689）：普通SMTP连接必须升级为加密连接，
+	// MAIL FROM附加REQUIRETLS参数，服务器不支持时报错而不发送
+	RequireTLS bool
+	// ArchiveBCC 归档地址，通过该配置发送的每封邮件都密送一份到该地址，优先于Email.ArchiveBCC
+	ArchiveBCC string
+	// FromAddress 默认发件人地址，为空时使用Username
+	FromAddress string
+	// AuthorizedDomains 该中继被授权（SPF/DKIM）发送的发件人域名，非空时发送前检查From头的域名，
+	// 不在列表中时按Email.RejectMisalignedFrom记录警告或拒绝发送
+	AuthorizedDomains []string
+	// EnvelopeSenders 该中继被授权（SPF）使用的信封发件人地址，用于同时代发多个域名的中继；
+	// 其中某个地址的域名与From头的域名相同时以该地址作为MAIL FROM，使SPF与From对齐，
+	// 否则使用默认的信封发件人（Email.BounceAddress或Username）
+	EnvelopeSenders []string
+
+	// certs 客户端证书的缓存，由New和ReplaceConfig创建
+	certs *certLoader
 }
 type Email struct {
-	mapper map[string]*ConfigMapper
+	// Aliases 分发列表别名地址，发送结果中会标记别名并保留服务器对RCPT TO的响应
+	Aliases []string
+	// OnConnect 每次建立新连接后调用（包括失败的连接），报告各阶段的耗时；复用连接池中的连接时不调用
+	OnConnect func(ConnectMetrics)
+	// Logf 日志输出函数，为nil时不输出日志
+	Logf func(format string, args ...any)
+	// Funcs 模板函数，SendTemplate和SendPersonalized渲染主题和正文时可用
+	Funcs template.FuncMap
+	// AllowedDomains 允许的收件人域名，非空时其他域名的收件人在发送前以ErrRecipientNotAllowed失败，
+	// 用于只应发往内部域名的服务；按RewriteRecipient改写后的地址检查，域名不区分大小写且不包括子域名
+	AllowedDomains []string
+	// DeniedDomains 禁止的收件人域名，优先于AllowedDomains
+	DeniedDomains []string
+	// StrictRouting 严格路由模式，域名没有对应配置时报错而不回退到默认配置
+	StrictRouting bool
+	// MaxRecipientsPerSend 一次发送允许的最大收件人总数，超过时整次发送以ErrTooManyRecipients失败，不发送任何邮件；
+	// 用于防止程序错误传入意外的大列表。BatchSend按所有邮件的信封收件人总数计算，0表示不限制
+	MaxRecipientsPerSend int
+	// PreflightRouting 发送前检查所有收件人的路由，任一收件人无法路由时不发送任何邮件
+	PreflightRouting bool
+	// ReuseConnection 同一次Send中使用相同配置的收件人共享一个连接，依次投递
+	ReuseConnection bool
+	// MaxConcurrency 单次发送中同时进行的最大连接数，0表示不限制
+	MaxConcurrency int
+	// DefaultHTML Send、SendGroup、SendTemplate等未传入isHTML参数时是否发送HTML格式邮件；
+	// 传入isHTML时以传入的值为准。SendWithOptions等使用SendOptions的方法以SendOptions.HTML为准，不受影响
+	DefaultHTML bool
+	// DateLocation 生成Date头使用的时区，为nil时使用本地时区
+	DateLocation *time.Location
+	// Now 生成Date头使用的时钟，为nil时使用time.Now；测试中可与Rand一起传入以得到逐字节稳定的邮件内容
+	Now func() time.Time
+	// Rand 生成Message-ID、MIME边界和队列中邮件ID使用的随机源，为nil时使用crypto/rand.Reader；
+	// 测试中可传入确定的随机源以得到稳定的邮件内容，生产环境应保持为nil。读取时加锁，可以被并发发送共享
+	Rand io.Reader
+	// BeforeSend 每个收件人的邮件投递前调用，可用于临时检查退订名单、扣减配额等；返回错误时跳过该收件人，
+	// 错误原样记录在发送结果中。recipient为RewriteRecipient改写后的地址，msg为中间件执行前的邮件；
+	// SendGroup中所有收件人共享同一个msg，可能被并发调用
+	BeforeSend func(ctx context.Context, recipient mail.Address, msg *Message) error
+	// Middleware 邮件中间件，序列化前按顺序执行，可修改邮件或返回错误拒绝发送
+	Middleware []func(*Message) error
+	// LoopDetection 开启转发环路检测，邮件已包含信封收件人的Delivered-To头或Received头超过MaxHops时拒绝发送
+	LoopDetection bool
+	// MaxHops 环路检测允许的最大Received头数量，0表示使用默认值100
+	MaxHops int
+	// MaxBodySize 正文（Body和Text分别计算）允许的最大字节数，0表示不限制
+	MaxBodySize int
+	// LineEnding WriteMessage写出邮件时使用的换行符，见LineEndingCRLF等常量；SMTP投递总是使用CRLF
+	LineEnding string
+	// MaxTotalAttachmentSize 一封邮件中附件编码后（base64）的总字节数上限，超过时构建邮件失败，
+	// 不建立连接；用于避免发送会被服务器拒绝的邮件（许多服务器限制为25MB），0表示不限制
+	MaxTotalAttachmentSize int
+	// TruncateBody 正文超过MaxBodySize时截断并附加截断提示，为false时拒绝发送
+	TruncateBody bool
+	// BounceAddress 退信地址，作为MAIL FROM的信封发件人，为空时使用配置中的Username
+	BounceAddress string
+	// VERP 开启VERP编码，每个收件人使用独立的信封发件人，如bounce+user=example.com@mydomain.com；
+	// 开启后多收件人邮件也按收件人分别投递
+	VERP bool
+	// ArchiveBCC 归档地址，每封邮件都密送一份到该地址，只出现在RCPT TO中而不写入邮件头
+	ArchiveBCC string
+	// RewriteRecipient 改写收件人地址，在选择配置和构造信封之前执行，如测试环境将所有邮件重定向到测试邮箱；
+	// 地址被改写时原地址保存在X-Original-To头中
+	RewriteRecipient func(mail.Address) mail.Address
+	// MaxRejectedRecipients 一次投递中被拒绝的收件人超过该数量时不发送DATA，已接受的收件人以ErrTooManyRejections失败；
+	// 0表示不限制，只要有收件人被接受就发送
+	MaxRejectedRecipients int
+	// MaxRejectedRatio 一次投递中被拒绝的收件人比例超过该值（如0.5）时不发送DATA，0表示不限制
+	MaxRejectedRatio float64
+	// Quit 结束会话的方式，见QuitWait等常量；开启Pool时连接归还到连接池，不受影响
+	Quit string
+	// Retry 发送失败时的重试策略，默认不重试；连接阶段的重试适用于所有发送方式，
+	// 投递阶段的重试适用于逐个收件人建立连接的发送（未开启ReuseConnection的Send等）
+	Retry RetryPolicy
+	// Pool 开启连接池，连接在多次发送之间复用；取出空闲连接时先用NOOP检查连接是否有效，
+	// 失效时自动重新连接。不再使用时应调用Close
+	Pool bool
+	// MaxIdle 连接池中连接的最长空闲时间，超过后被关闭，0表示使用默认值30秒
+	MaxIdle time.Duration
+	// KeepAlive 连接池中空闲连接发送NOOP保持活跃的间隔，防止服务器在空闲期间关闭连接；NOOP失败的连接被丢弃，
+	// 0表示不发送。空闲时间仍按MaxIdle计算，需要长时间保持连接时应同时调大MaxIdle
+	KeepAlive time.Duration
+	// Queue Enqueue使用的发送队列，为nil时使用内存队列；实现持久化的队列可以在程序重启后继续投递
+	Queue Queue
+	// QueueRetryDelay 队列中的邮件投递失败后到下次投递的间隔，写入QueuedMessage.NotBefore，0表示使用默认值1分钟
+	QueueRetryDelay time.Duration
+	// Mailer X-Mailer头的值，为空时使用默认值"liu-dc/email <版本>"；邮件已设置X-Mailer头时不覆盖
+	Mailer string
+	// AutoSubmitted Auto-Submitted头的值，见AutoGenerated等常量，为空时不添加；
+	// 用于避免自动回复和退信形成循环（RFC 3834），邮件已设置该头时不覆盖
+	AutoSubmitted string
+	// DisableMailer 不添加X-Mailer头，避免暴露发送软件的信息
+	DisableMailer bool
+	// DefaultSubject 主题为空（或只有空白）时使用的主题，在中间件之后替换
+	DefaultSubject string
+	// RejectEmptySubject 主题为空且没有设置DefaultSubject时以ErrEmptySubject拒绝发送；
+	// 两者都未设置时照常发送空的Subject头
+	RejectEmptySubject bool
+	// Privacy 隐私模式：Date头使用UTC时间（忽略DateLocation），不添加X-Mailer头，
+	// 并移除邮件中的X-Originating-IP、X-Mailer和User-Agent头，避免泄露发件人的时区、IP和客户端信息
+	Privacy bool
+	// StripHeaders 发送前从每封邮件中移除的邮件头，在中间件之后执行，名称不区分大小写；
+	// 不能移除To、From、Subject等由序列化生成的头
+	StripHeaders []string
+	// Force7Bit 保证整封邮件只包含7位ASCII字符：主题和邮件头按RFC 2047编码，8bit正文改用quoted-printable，
+	// 序列化后仍包含8位字节时（如非ASCII的邮箱地址）返回错误而不发送
+	Force7Bit bool
+	// BinaryMIME 服务器同时支持BINARYMIME和CHUNKING扩展（RFC 3030）时，附件以原始字节发送（Content-Transfer-Encoding: binary），
+	// 使用BDAT代替DATA，避免base64约33%的体积增加；服务器不支持或开启Force7Bit时仍按base64通过DATA发送
+	BinaryMIME bool
+	// CheckFromAlignment 配置没有设置AuthorizedDomains且From头的域名与Username的域名不同时，通过Logf记录DMARC对齐警告，不阻止发送
+	CheckFromAlignment bool
+	// RejectMisalignedFrom From头的域名不在配置的AuthorizedDomains中时拒绝发送，为false时只通过Logf记录警告
+	RejectMisalignedFrom bool
+	// SuppressInsecureWarning 不记录SkipTLSVerify警告；默认每个开启SkipTLSVerify的服务器地址首次发送时通过Logf记录一次警告
+	SuppressInsecureWarning bool
+
+	// mapperMu 保护mapper和warnings，ReplaceConfig整体替换
+	mapperMu sync.RWMutex
+	mapper   map[string]*ConfigMapper
+	warnings []error
+	// insecureWarned 已记录过SkipTLSVerify警告的服务器地址
+	insecureMu     sync.Mutex
+	insecureWarned map[string]bool
+	// overrides 合并了TLSOverride的配置副本，ReplaceConfig时清空
+	overrideMu sync.Mutex
+	overrides  map[tlsOverrideKey]*ConfigMapper
+	// randMu 并发构建的邮件共享Rand，读取时加锁
+	randMu sync.Mutex
+	poolMu sync.Mutex
+	pool   *connPool
+
+	// queueCancel 停止发送队列的后台任务，queueDone在后台任务结束时关闭
+	queueMu     sync.Mutex
+	queueCancel context.CancelFunc
+	queueDone   chan struct{}
+
+	// inflight 进行中的异步发送数，归零时关闭drained
+	inflightMu sync.Mutex
+	inflight   int
+	drained    chan struct{}
 }
 
-// validateConfig 验证配置的有效性
-func validateConfig(mapper map[string]*ConfigMapper) error {
+// validateConfig 验证配置的有效性，返回发现的所有问题
+func validateConfig(mapper map[string]*ConfigMapper) []error {
 	if len(mapper) == 0 {
-		return errors.New("empty configuration mapper")
+		return []error{errors.New("empty configuration mapper")}
 	}
 
-	// 检查是否有默认配置
-	if _, hasDefault := mapper["default"]; !hasDefault {
-		// 如果没有默认配置，确保所有配置都有效
-		for domain, config := range mapper {
-			if err := validateSingleConfig(config); err != nil {
-				return fmt.Errorf("invalid configuration for domain %s: %v", domain, err)
-			}
+	domains := make([]string, 0, len(mapper))
+	for domain := range mapper {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var errs []error
+	// 如果有默认配置，确保默认配置有效；没有默认配置时，其他域名的收件人将以ErrNoConfig失败
+	if config, hasDefault := mapper["default"]; hasDefault {
+		if err := validateSingleConfig(config); err != nil {
+			errs = append(errs, fmt.Errorf("invalid default configuration: %v", err))
 		}
 	} else {
-		// 如果有默认配置，确保默认配置有效
-		if err := validateSingleConfig(mapper["default"]); err != nil {
-			return fmt.Errorf("invalid default configuration: %v", err)
+		errs = append(errs, wrapSentinel(ErrNoConfig, "no default configuration: recipients outside %s will fail", strings.Join(domains, ", ")))
+	}
+	for _, domain := range domains {
+		if domain == "default" {
+			continue
 		}
-
-		// 其他配置可以部分有效（会回退到默认配置）
-		for domain, config := range mapper {
-			if domain != "default" && config != nil {
-				_ = validateSingleConfig(config) // 非默认配置的验证失败不影响整体
-			}
+		if err := validateSingleConfig(mapper[domain]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid configuration for domain %s: %v", domain, err))
 		}
 	}
+	return errs
+}
 
-	return nil
+// Validate 验证配置的有效性，发送前对选中的配置调用，配置无效的收件人不会建立连接
+func (c *ConfigMapper) Validate() error {
+	return validateSingleConfig(c)
 }
 
 // validateSingleConfig 验证单个配置项的有效性
@@ -111,182 +355,1560 @@ func validateSingleConfig(config *ConfigMapper) error {
 		return errors.New("empty username")
 	}
 
-	if config.Password == "" {
-		return errors.New("empty password")
+	// Username同时作为发件人地址，必须是有效的邮箱地址
+	if addr, err := mail.ParseAddress(config.Username); err != nil {
+		return fmt.Errorf("invalid username %q: %v", config.Username, err)
+	} else if addr.Name != "" || addr.Address != config.Username {
+		return fmt.Errorf("invalid username %q: must be a bare email address", config.Username)
+	}
+
+	if config.FromAddress != "" {
+		if addr, err := mail.ParseAddress(config.FromAddress); err != nil {
+			return fmt.Errorf("invalid from address %q: %v", config.FromAddress, err)
+		} else if addr.Name != "" || addr.Address != config.FromAddress {
+			return fmt.Errorf("invalid from address %q: must be a bare email address", config.FromAddress)
+		}
+	}
+
+	switch config.TLSMinVersion {
+	case 0, tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return fmt.Errorf("invalid TLS min version 0x%04x", config.TLSMinVersion)
+	}
+
+	if cert, err := clientCertificate(config); err != nil {
+		return fmt.Errorf("invalid client certificate: %v", err)
+	} else if cert != nil && !config.TLS && !config.OpportunisticTLS && !config.RequireTLS && config.DANE == DANENone {
+		return errors.New("client certificate requires TLS, OpportunisticTLS or RequireTLS")
+	}
+
+	for _, sender := range config.EnvelopeSenders {
+		if addr, err := mail.ParseAddress(sender); err != nil {
+			return fmt.Errorf("invalid envelope sender %q: %v", sender, err)
+		} else if addr.Name != "" || addr.Address != sender {
+			return fmt.Errorf("invalid envelope sender %q: must be a bare email address", sender)
+		}
+	}
+
+	switch config.HELOStrategy {
+	case HELODefault, HELOHostname, HELOReverseDNS, HELOLocalhost:
+	case HELOStatic:
+		if config.HELOName == "" {
+			return errors.New("static HELO strategy requires HELOName")
+		}
+	default:
+		return fmt.Errorf("unsupported HELO strategy %q", config.HELOStrategy)
+	}
+
+	switch config.DANE {
+	case DANENone:
+	case DANEOpportunistic, DANEMandatory:
+		if config.TLSAResolver == nil {
+			return errors.New("DANE requires a TLSA resolver")
+		}
+	default:
+		return fmt.Errorf("unsupported DANE mode %q", config.DANE)
+	}
+
+	switch config.AuthType {
+	case AuthDefault, AuthLogin, AuthPlain:
+		if config.Password == "" {
+			return errors.New("empty password")
+		}
+	case AuthNone:
+	default:
+		return fmt.Errorf("unsupported auth type %q", config.AuthType)
 	}
 
 	return nil
 }
 
 // New 创建一个新的Email实例
+// 配置验证失败时仍然创建实例（允许后续修复配置），问题可通过ConfigWarnings获取
 func New(mapper map[string]*ConfigMapper) *Email {
-	// 验证配置
-	if err := validateConfig(mapper); err != nil {
-		// 配置验证失败时记录警告，但仍然创建实例（允许后续修复配置）
-		fmt.Printf("Warning: %v\n", err)
+	attachCertLoaders(mapper)
+	return &Email{
+		mapper:   mapper,
+		warnings: validateConfig(mapper),
 	}
+}
 
-	return &Email{
-		mapper: mapper,
+// ConfigWarnings 返回创建实例时配置验证发现的问题，ReplaceConfig成功后为新配置的问题
+func (m *Email) ConfigWarnings() []error {
+	m.mapperMu.RLock()
+	defer m.mapperMu.RUnlock()
+	return m.warnings
+}
+
+// ReplaceConfig 验证新的配置映射表并整体替换，用于配置热更新
+// 已经选定配置的发送继续使用原来的配置，之后的发送使用新配置；连接池中原配置的空闲连接按MaxIdle清理。
+// 新配置存在无效的项时返回所有问题且不替换；缺少默认配置只作为警告，可通过ConfigWarnings获取。
+// 调用方不应在替换后修改传入的映射表
+func (m *Email) ReplaceConfig(mapper map[string]*ConfigMapper) error {
+	attachCertLoaders(mapper)
+	warnings := validateConfig(mapper)
+	var errs []error
+	for _, err := range warnings {
+		if !errors.Is(err, ErrNoConfig) {
+			errs = append(errs, err)
+		}
 	}
+	if len(errs) > 0 {
+		return fmt.Errorf("email: configuration not replaced: %w", errors.Join(errs...))
+	}
+	m.mapperMu.Lock()
+	m.mapper, m.warnings = mapper, warnings
+	m.mapperMu.Unlock()
+	m.overrideMu.Lock()
+	m.overrides = nil
+	m.overrideMu.Unlock()
+	return nil
 }
 
-// GetMapper 根据邮箱地址获取对应的配置
-func (m *Email) GetMapper(email string) (*ConfigMapper, bool) {
+// checkFrom 检查发件人域名是否在配置的AuthorizedDomains中；未配置AuthorizedDomains时，
+// 开启CheckFromAlignment则在From域名与Username域名不同时记录警告
+// 域名不匹配时，开启RejectMisalignedFrom返回错误，否则记录警告并返回nil
+func (m *Email) checkFrom(config *ConfigMapper, from mail.Address) error {
+	domain := domainOf(from.Address)
+	if len(config.AuthorizedDomains) == 0 {
+		// 没有配置授权域名时，From域名与认证用户的域名不同可能导致DMARC校验失败
+		if m.CheckFromAlignment && m.Logf != nil && !strings.EqualFold(domain, domainOf(config.Username)) {
+			m.Logf("email: from domain %q differs from username domain %q; DMARC may fail unless the relay is authorized for it (set AuthorizedDomains)",
+				domain, domainOf(config.Username))
+		}
+		return nil
+	}
+	for _, authorized := range config.AuthorizedDomains {
+		if strings.EqualFold(domain, authorized) {
+			return nil
+		}
+	}
+	err := fmt.Errorf("email: from domain %q is not authorized for relay %s", domain, config.Host)
+	if m.RejectMisalignedFrom {
+		return err
+	}
+	if m.Logf != nil {
+		m.Logf("%v", err)
+	}
+	return nil
+}
+
+// domainOf 提取邮箱地址的域名，无法提取时返回空字符串
+func domainOf(email string) string {
 	// 解析邮箱地址，提取域名
 	addr, err := mail.ParseAddress(email)
-	var domain = "default"
 	if err == nil {
 		// 从解析后的邮箱地址中提取域名
 		if idx := strings.LastIndex(addr.Address, "@"); idx != -1 {
-			domain = addr.Address[idx+1:]
-		}
-	} else {
-		// 解析失败时回退到简单分割
-		domainSplit := strings.Split(email, "@")
-		if len(domainSplit) >= 2 {
-			domain = domainSplit[len(domainSplit)-1]
+			return addr.Address[idx+1:]
 		}
+		return ""
 	}
+	// 解析失败时回退到简单分割
+	domainSplit := strings.Split(email, "@")
+	if len(domainSplit) >= 2 {
+		return domainSplit[len(domainSplit)-1]
+	}
+	return ""
+}
+
+// GetMapper 根据邮箱地址获取对应的配置
+// StrictRouting开启时不回退到默认配置
+func (m *Email) GetMapper(email string) (*ConfigMapper, bool) {
+	m.mapperMu.RLock()
+	configs := m.mapper
+	m.mapperMu.RUnlock()
 
 	// 首先查找域名对应的配置
-	if mapper, ok := m.mapper[domain]; ok {
-		return mapper, true
+	if domain := domainOf(email); domain != "" {
+		if mapper, ok := configs[domain]; ok {
+			return mapper, true
+		}
+	}
+
+	if m.StrictRouting {
+		return nil, false
 	}
 
 	// 如果域名没有配置，使用默认配置
-	if mapper, ok := m.mapper["default"]; ok {
+	if mapper, ok := configs["default"]; ok {
 		return mapper, true
 	}
 
 	return nil, false
 }
 
-// buildMessage 构建邮件消息
-func buildMessage(from, to mail.Address, subject, contentType, content string) []byte {
-	return []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\n%s\r\n\r\n%s",
-		to.String(), from.String(), subject, contentType, content))
+// newTLSConfig 根据配置生成TLS配置
+func newTLSConfig(config *ConfigMapper) *tls.Config {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SkipTLSVerify,
+		ServerName:         config.Host,
+		MinVersion:         tls.VersionTLS12, // 默认只支持TLS 1.2及以上版本
+	}
+	if config.TLSServerName != "" {
+		tlsConfig.ServerName = config.TLSServerName
+	}
+	if config.TLSMinVersion != 0 {
+		tlsConfig.MinVersion = config.TLSMinVersion
+	}
+	return tlsConfig
+}
+
+// certSource 客户端证书的来源，配置的证书字段被修改后重新加载
+type certSource struct {
+	certFile, keyFile string
+	certPEM, keyPEM   string
 }
 
-// sendPlainMail 发送普通SMTP邮件
-func sendPlainMail(config *ConfigMapper, from mail.Address, to string, message []byte) error {
-	auth := &NotAuth{
-		Host:     config.Host,
-		Username: config.Username,
-		Password: config.Password,
+// certLoader 缓存配置的客户端证书，验证配置和每次建立连接时不再重复读取和解析
+// 复制的配置（如TLSOverride生成的副本）共享同一个缓存，证书字段与缓存时不同则重新加载
+type certLoader struct {
+	mu     sync.Mutex
+	source certSource
+	cert   *tls.Certificate
+}
+
+// attachCertLoaders 为映射表中还没有证书缓存的配置创建缓存
+func attachCertLoaders(mapper map[string]*ConfigMapper) {
+	for _, config := range mapper {
+		if config != nil && config.certs == nil {
+			config.certs = &certLoader{}
+		}
 	}
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	return smtp.SendMail(addr, auth, config.Username, []string{to}, message)
 }
 
-// sendTLSMail 发送TLS加密邮件
-func sendTLSMail(config *ConfigMapper, from mail.Address, to mail.Address, message []byte) error {
-	// TLS配置
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.SkipTLSVerify,
-		ServerName:         config.Host,
-		MinVersion:         tls.VersionTLS12, // 只支持TLS 1.2及以上版本
+// clientCertificate 加载配置的客户端证书，没有配置时返回nil和nil
+// 通过New或ReplaceConfig添加的配置只加载一次，加载失败不缓存，下次使用时重试；其他配置每次重新加载
+func clientCertificate(config *ConfigMapper) (*tls.Certificate, error) {
+	source := certSource{
+		certFile: config.ClientCertFile,
+		keyFile:  config.ClientKeyFile,
+		certPEM:  string(config.ClientCertPEM),
+		keyPEM:   string(config.ClientKeyPEM),
+	}
+	if source == (certSource{}) {
+		return nil, nil
+	}
+	if config.certs == nil {
+		return loadCertificate(config)
+	}
+	config.certs.mu.Lock()
+	defer config.certs.mu.Unlock()
+	if config.certs.cert != nil && config.certs.source == source {
+		return config.certs.cert, nil
+	}
+	cert, err := loadCertificate(config)
+	if err != nil {
+		return nil, err
+	}
+	config.certs.source, config.certs.cert = source, cert
+	return cert, nil
+}
+
+// loadCertificate 读取并解析配置的客户端证书，PEM内容优先于文件
+func loadCertificate(config *ConfigMapper) (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	if len(config.ClientCertPEM) > 0 || len(config.ClientKeyPEM) > 0 {
+		cert, err = tls.X509KeyPair(config.ClientCertPEM, config.ClientKeyPEM)
+	} else {
+		cert, err = tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// warnInsecure 配置开启SkipTLSVerify时通过Logf记录警告，每个服务器地址只记录一次
+// 按地址而不是配置去重，TLSOverride每次发送生成的配置副本不会重复记录
+func (m *Email) warnInsecure(config *ConfigMapper) {
+	if !config.SkipTLSVerify || m.SuppressInsecureWarning || m.Logf == nil {
+		return
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	m.insecureMu.Lock()
+	if m.insecureWarned[addr] {
+		m.insecureMu.Unlock()
+		return
+	}
+	if m.insecureWarned == nil {
+		m.insecureWarned = make(map[string]bool)
+	}
+	m.insecureWarned[addr] = true
+	m.insecureMu.Unlock()
+	m.Logf("email: TLS certificate verification is disabled for %s (SkipTLSVerify); connections are open to interception, do not use it in production", addr)
+}
+
+// TLSOverride 只对一次发送生效的TLS设置，覆盖所选配置中的对应字段而不修改配置本身
+type TLSOverride struct {
+	SkipTLSVerify *bool  // 非nil时覆盖ConfigMapper.SkipTLSVerify
+	ServerName    string // 非空时覆盖ConfigMapper.TLSServerName
+	MinVersion    uint16 // 非0时覆盖ConfigMapper.TLSMinVersion
+}
+
+// tlsOverrideKey 合并了TLSOverride的配置副本的键：原配置和合并后的TLS设置
+type tlsOverrideKey struct {
+	base          *ConfigMapper
+	skipTLSVerify bool
+	serverName    string
+	minVersion    uint16
+}
+
+// applyTLS 返回合并了o的配置，o为nil或合并后与config相同时返回config本身
+// 同一配置与相同TLS设置的组合总是返回同一个副本，开启Pool时这些发送共享连接池中的连接
+func (m *Email) applyTLS(o *TLSOverride, config *ConfigMapper) *ConfigMapper {
+	if o == nil {
+		return config
+	}
+	unchanged := tlsOverrideKey{config, config.SkipTLSVerify, config.TLSServerName, config.TLSMinVersion}
+	key := unchanged
+	if o.SkipTLSVerify != nil {
+		key.skipTLSVerify = *o.SkipTLSVerify
+	}
+	if o.ServerName != "" {
+		key.serverName = o.ServerName
+	}
+	if o.MinVersion != 0 {
+		key.minVersion = o.MinVersion
 	}
-	// 建立TLS连接
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", config.Host, config.Port), tlsConfig)
+	if key == unchanged {
+		return config
+	}
+
+	m.overrideMu.Lock()
+	defer m.overrideMu.Unlock()
+	if copied, ok := m.overrides[key]; ok {
+		return copied
+	}
+	copied := *config
+	copied.SkipTLSVerify, copied.TLSServerName, copied.TLSMinVersion = key.skipTLSVerify, key.serverName, key.minVersion
+	if m.overrides == nil {
+		m.overrides = make(map[tlsOverrideKey]*ConfigMapper)
+	}
+	m.overrides[key] = &copied
+	return &copied
+}
+
+// authMechanism 返回配置使用的认证方式，AuthDefault按连接类型解析为LOGIN或PLAIN
+func authMechanism(config *ConfigMapper) string {
+	if config.AuthType != AuthDefault {
+		return config.AuthType
+	}
+	if config.TLS {
+		return AuthPlain
+	}
+	return AuthLogin
+}
+
+// newAuth 根据配置生成身份验证方式，AuthNone或没有配置密码时返回nil
+func newAuth(config *ConfigMapper) smtp.Auth {
+	if config.Password == "" {
+		return nil
+	}
+	switch authMechanism(config) {
+	case AuthNone:
+		return nil
+	case AuthPlain:
+		return smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	default:
+		return &NotAuth{
+			Host:     config.Host,
+			Username: config.Username,
+			Password: config.Password,
+		}
+	}
+}
+
+// checkAuth 确认服务器在EHLO中声明了配置使用的认证方式
+// 未检查时服务器会对AUTH返回含义不明的错误，或在MAIL FROM时才要求认证
+// 明文连接上没有声明所需的认证方式时返回ErrUnencrypted，许多服务器只在加密连接上提供认证
+func checkAuth(smtpClient *smtp.Client, config *ConfigMapper) error {
+	ok, mechanisms := smtpClient.Extension("AUTH")
+	if !ok {
+		if startTLS, _ := smtpClient.Extension("STARTTLS"); startTLS {
+			return fmt.Errorf("%w for %s auth; set TLS or OpportunisticTLS", ErrUnencrypted, authMechanism(config))
+		}
+		return errors.New("email: server does not advertise AUTH; use AuthNone if no authentication is required")
+	}
+	mechanism := authMechanism(config)
+	for _, advertised := range strings.Fields(mechanisms) {
+		if strings.EqualFold(advertised, mechanism) {
+			return nil
+		}
+	}
+	if _, secure := smtpClient.TLSConnectionState(); !secure {
+		return fmt.Errorf("%w for %s auth; set TLS or OpportunisticTLS", ErrUnencrypted, mechanism)
+	}
+	return fmt.Errorf("email: server does not support AUTH %s (advertised: %s)", mechanism, mechanisms)
+}
+
+// dial 根据配置建立连接并完成身份验证，同时返回底层连接以便设置截止时间
+// 会话的截止时间取Timeout与ctx截止时间中较早的一个
+// 各阶段的耗时记录到metrics
+func dial(ctx context.Context, config *ConfigMapper, metrics *ConnectMetrics) (*smtp.Client, net.Conn, error) {
+	records, err := daneRecords(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to create TLS connection: %v", err)
+		return nil, nil, err
+	}
+	tlsConfig := daneTLSConfig(config, records)
+	if cert, err := clientCertificate(config); err != nil {
+		return nil, nil, fmt.Errorf("email: failed to load client certificate: %w", err)
+	} else if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	metrics.Dial = time.Since(start)
+	if err != nil {
+		if config.TLS {
+			return nil, nil, fmt.Errorf("failed to create TLS connection: %w", err)
+		}
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	// 命令超时需要在TLS之下的连接上设置读取截止时间
+	if config.CommandTimeout > 0 {
+		conn = &commandConn{Conn: conn, timeout: config.CommandTimeout}
 	}
-	defer func(conn *tls.Conn) {
+	if config.TLS {
+		// TLS=true时使用TLS加密连接，握手与TCP连接分开完成以便分别计时
+		if config.Timeout > 0 {
+			_ = conn.SetDeadline(time.Now().Add(config.Timeout))
+		}
+		start = time.Now()
+		tlsConn := tls.Client(conn, tlsConfig)
+		err = tlsConn.HandshakeContext(ctx)
+		metrics.TLSHandshake = time.Since(start)
+		if err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("failed to create TLS connection: %w", err)
+		}
+		conn = tlsConn
+	}
+	deadline := sessionDeadline(ctx, config)
+	greeting := deadline
+	if config.GreetingTimeout > 0 {
+		if limit := time.Now().Add(config.GreetingTimeout); greeting.IsZero() || limit.Before(greeting) {
+			greeting = limit
+		}
+	}
+	if !greeting.IsZero() {
+		_ = conn.SetDeadline(greeting)
+	}
+
+	// 创建SMTP客户端，NewClient读取服务器的220问候
+	// 普通连接的问候在连接层限制行长度，之后改为限制客户端的响应读取
+	var greetingLimit *lineLimiter
+	clientConn := conn
+	if !config.TLS {
+		greetingLimit = &lineLimiter{r: conn, max: maxResponseLine(config)}
+		clientConn = &limitedConn{Conn: conn, r: greetingLimit}
+	}
+	smtpClient, err := smtp.NewClient(clientConn, config.Host)
+	if greetingLimit != nil {
+		greetingLimit.max = 0
+	}
+	if err != nil {
 		_ = conn.Close()
-	}(conn)
-	// 创建SMTP客户端
-	smtpClient, err := smtp.NewClient(conn, config.Host)
+		if isTimeout(err) && !greeting.Equal(deadline) {
+			return nil, nil, fmt.Errorf("failed to read greeting within %v: %w", config.GreetingTimeout, err)
+		}
+		return nil, nil, fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+	if !greeting.Equal(deadline) {
+		_ = conn.SetDeadline(deadline)
+	}
+	limitResponses(smtpClient, config)
+	// TLS加密连接在握手完成后才发送EHLO，因此服务器能力总是在加密通道上获取
+	// 总是显式发送EHLO：标准库在首次查询扩展时隐式发送EHLO，但会忽略其错误
+	name := heloName(config, conn.LocalAddr())
+	if name == "" {
+		name = "localhost"
+	}
+	if err = smtpClient.Hello(name); err != nil {
+		_ = smtpClient.Close()
+		return nil, nil, fmt.Errorf("failed to send HELO: %w", wrapSMTPError(smtpClient, err))
+	}
+
+	// 服务器支持STARTTLS时升级为加密连接，必须在认证前完成
+	// StartTLS完成后会使用同一HELO名称重新发送EHLO并重新解析服务器能力
+	// 开启AuthStartTLS时只在明文连接上缺少所需的认证方式时升级
+	upgrade := config.OpportunisticTLS || config.RequireTLS || len(records) > 0 ||
+		config.AuthStartTLS && newAuth(config) != nil && checkAuth(smtpClient, config) != nil
+	if !config.TLS && upgrade {
+		if ok, _ := smtpClient.Extension("STARTTLS"); ok {
+			start = time.Now()
+			err = smtpClient.StartTLS(tlsConfig)
+			metrics.TLSHandshake = time.Since(start)
+			if err != nil {
+				_ = smtpClient.Close()
+				return nil, nil, fmt.Errorf("failed to start TLS: %w", wrapSMTPError(smtpClient, err))
+			}
+			limitResponses(smtpClient, config)
+		}
+	}
+
+	// 存在可用的TLSA记录时不能降级为明文传输
+	if _, secure := smtpClient.TLSConnectionState(); len(records) > 0 && !secure {
+		_ = smtpClient.Close()
+		return nil, nil, errors.New("email: server does not support STARTTLS required by DANE")
+	}
+
+	// REQUIRETLS只能在加密连接上使用，服务器不支持时不能在没有保证的情况下发送
+	if config.RequireTLS {
+		_, secure := smtpClient.TLSConnectionState()
+		if ok, _ := smtpClient.Extension("REQUIRETLS"); !secure || !ok {
+			_ = smtpClient.Close()
+			return nil, nil, errors.New("email: server does not support REQUIRETLS")
+		}
+	}
+
+	// 身份验证，没有配置凭据时跳过
+	authConfig := startTLSAuthConfig(smtpClient, config)
+	if auth := newAuth(authConfig); auth != nil {
+		if err = checkAuth(smtpClient, authConfig); err != nil {
+			_ = smtpClient.Close()
+			return nil, nil, err
+		}
+		start = time.Now()
+		err = smtpClient.Auth(auth)
+		metrics.Auth = time.Since(start)
+		if err != nil {
+			_ = smtpClient.Close()
+			return nil, nil, &authError{err: wrapSMTPError(smtpClient, err)}
+		}
+	}
+	return smtpClient, conn, nil
+}
+
+// startTLSAuthConfig 开启AuthStartTLS且连接已通过STARTTLS加密时，AuthDefault在服务器不支持LOGIN而支持PLAIN时改用PLAIN
+// 其他情况返回config本身
+func startTLSAuthConfig(smtpClient *smtp.Client, config *ConfigMapper) *ConfigMapper {
+	if !config.AuthStartTLS || config.AuthType != AuthDefault || config.TLS {
+		return config
+	}
+	if _, secure := smtpClient.TLSConnectionState(); !secure || checkAuth(smtpClient, config) == nil {
+		return config
+	}
+	copied := *config
+	copied.AuthType = AuthPlain
+	if checkAuth(smtpClient, &copied) != nil {
+		return config
+	}
+	return &copied
+}
+
+// hostname 返回本机主机名，测试时可替换
+var hostname = os.Hostname
+
+// lookupAddr 反向DNS查询，测试时可替换
+var lookupAddr = net.LookupAddr
+
+// heloName 返回EHLO/HELO使用的名称，为空时使用localhost
+// 优先级：HELOName、HELOStrategy选择的名称、HELOAddressLiteral、localhost；
+// HELOHostname和HELOReverseDNS无法取得名称时按后两者处理
+// 开启HELOAddressLiteral时，本机主机名不是可解析的完整域名则使用本地地址的地址字面量
+func heloName(config *ConfigMapper, local net.Addr) string {
+	if config.HELOName != "" {
+		return config.HELOName
+	}
+	switch config.HELOStrategy {
+	case HELOHostname:
+		if name, err := hostname(); err == nil && name != "" {
+			return name
+		}
+	case HELOReverseDNS:
+		if addr, ok := local.(*net.TCPAddr); ok {
+			if names, err := lookupAddr(addr.IP.String()); err == nil && len(names) > 0 {
+				return strings.TrimSuffix(names[0], ".")
+			}
+		}
+	case HELOLocalhost:
+		return "localhost"
+	}
+	if !config.HELOAddressLiteral {
+		return ""
+	}
+	if name, err := hostname(); err == nil && strings.Contains(name, ".") {
+		if _, err = net.LookupHost(name); err == nil {
+			return name
+		}
+	}
+	if addr, ok := local.(*net.TCPAddr); ok {
+		return addressLiteral(addr.IP)
+	}
+	return ""
+}
+
+// addressLiteral 生成RFC 5321格式的地址字面量：IPv4为[192.0.2.1]，IPv6为[IPv6:2001:db8::1]
+func addressLiteral(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "[" + ip4.String() + "]"
+	}
+	return "[IPv6:" + ip.String() + "]"
+}
+
+// sessionDeadline 返回会话的截止时间，取Timeout与ctx截止时间中较早的一个，零值表示不限制
+func sessionDeadline(ctx context.Context, config *ConfigMapper) time.Time {
+	var deadline time.Time
+	if config.Timeout > 0 {
+		deadline = time.Now().Add(config.Timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// successCode 判断MAIL FROM、RCPT TO或DATA结束时的响应码是否表示成功，默认任何2xx响应码都表示成功
+func successCode(config *ConfigMapper, code int) bool {
+	if config.SuccessCode != nil {
+		return config.SuccessCode(code)
+	}
+	return code >= 200 && code < 300
+}
+
+// readReply 读取一个响应并按successCode判断是否成功，失败时返回*textproto.Error
+// 标准库对MAIL FROM和DATA结束只接受250，部分网关会返回251等其他2xx响应码
+func readReply(text *textproto.Conn, config *ConfigMapper) (int, string, error) {
+	code, msg, err := text.ReadResponse(0)
 	if err != nil {
-		return fmt.Errorf("failed to create SMTP client: %v", err)
+		return code, msg, err
+	}
+	if !successCode(config, code) {
+		return code, msg, &textproto.Error{Code: code, Msg: msg}
 	}
-	defer func(smtpClient *smtp.Client) {
-		_ = smtpClient.Quit()
-	}(smtpClient)
+	return code, msg, nil
+}
 
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
-	// 身份验证
-	if err = smtpClient.Auth(auth); err != nil {
-		return fmt.Errorf("authentication failed: %v", err)
+// mailFrom 发送MAIL FROM命令，params为附加的参数
+func mailFrom(smtpClient *smtp.Client, config *ConfigMapper, from, params string) error {
+	if strings.ContainsAny(from, "\r\n") {
+		return errors.New("smtp: A line must not contain CR or LF")
 	}
-	// 发送邮件
-	if err = smtpClient.Mail(from.Address); err != nil {
-		return fmt.Errorf("failed to set sender: %v", err)
+	id, err := smtpClient.Text.Cmd("MAIL FROM:<%s>%s", from, params)
+	if err != nil {
+		return err
 	}
-	if err = smtpClient.Rcpt(to.Address); err != nil {
-		return fmt.Errorf("failed to set recipient: %v", err)
+	smtpClient.Text.StartResponse(id)
+	defer smtpClient.Text.EndResponse(id)
+	_, _, err = readReply(smtpClient.Text, config)
+	return err
+}
+
+// rcpt 发送RCPT TO命令并返回服务器的响应文本
+// 标准库的Rcpt会丢弃响应文本，而别名展开等信息只能从响应中获得
+func rcpt(smtpClient *smtp.Client, config *ConfigMapper, to string) (string, error) {
+	if strings.ContainsAny(to, "\r\n") {
+		return "", errors.New("smtp: A line must not contain CR or LF")
 	}
-	wc, err := smtpClient.Data()
+	id, err := smtpClient.Text.Cmd("RCPT TO:<%s>", to)
 	if err != nil {
-		return fmt.Errorf("failed to send data: %v", err)
+		return "", err
 	}
-	_, err = wc.Write(message)
+	smtpClient.Text.StartResponse(id)
+	defer smtpClient.Text.EndResponse(id)
+	code, msg, err := readReply(smtpClient.Text, config)
 	if err != nil {
-		return fmt.Errorf("failed to write message: %v", err)
+		return "", err
+	}
+	return fmt.Sprintf("%d %s", code, msg), nil
+}
+
+// startData 发送DATA命令并等待354响应
+func startData(smtpClient *smtp.Client) error {
+	id, err := smtpClient.Text.Cmd("DATA")
+	if err != nil {
+		return err
+	}
+	smtpClient.Text.StartResponse(id)
+	defer smtpClient.Text.EndResponse(id)
+	_, _, err = smtpClient.Text.ReadResponse(354)
+	return err
+}
+
+// transaction 在已认证的会话上完成一次邮件投递，结果写入results
+// 服务器支持PIPELINING时使用流水线方式发送命令
+// 开启BinaryMIME且服务器支持时附件以原始字节通过BDAT发送，此时不使用流水线；raw为true时邮件原样发送，不做BinaryMIME转换
+func (m *Email) transaction(smtpClient *smtp.Client, config *ConfigMapper, from string, results []*SendResult, message []byte, raw bool) {
+	binary := false
+	if m.BinaryMIME && !m.Force7Bit && !raw && supportsBinaryMIME(smtpClient) {
+		message, binary = binaryMessage(message)
+	}
+	if ok, _ := smtpClient.Extension("PIPELINING"); ok && !binary {
+		m.pipeline(smtpClient, config, from, results, message)
+		return
+	}
+
+	fail := func(err error) {
+		for _, result := range results {
+			if result.Err == nil {
+				result.Err = err
+			}
+		}
+		// 重置会话，保证连接可以继续用于后续投递；连接超时后不再发送命令
+		if !isTimeout(err) {
+			_ = smtpClient.Reset()
+		}
+	}
+
+	size := wireSize(message)
+	if binary {
+		size = len(message)
+	}
+	if err := mailFrom(smtpClient, config, from, mailParams(smtpClient, config, size, binary)); err != nil {
+		fail(fmt.Errorf("failed to set sender: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	accepted := 0
+	for _, result := range results {
+		response, err := rcpt(smtpClient, config, result.Recipient.Address)
+		if err != nil {
+			result.Err = fmt.Errorf("failed to set recipient: %w", wrapSMTPError(smtpClient, err))
+			continue
+		}
+		result.Response = response
+		if result.Alias && m.Logf != nil {
+			m.Logf("alias %s accepted: %s", result.Recipient.Address, response)
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		fail(nil)
+		return
+	}
+	if err := m.checkRejections(len(results)-accepted, len(results)); err != nil {
+		fail(err)
+		return
+	}
+	if binary {
+		if err := bdat(smtpClient, config, message); err != nil {
+			fail(fmt.Errorf("failed to send data: %w", wrapSMTPError(smtpClient, err)))
+		}
+		return
 	}
-	if err = wc.Close(); err != nil {
-		return fmt.Errorf("failed to close writer: %v", err)
+	if err := startData(smtpClient); err != nil {
+		fail(fmt.Errorf("failed to send data: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	w := smtpClient.Text.DotWriter()
+	if _, err := w.Write(message); err != nil {
+		fail(fmt.Errorf("failed to write message: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	if err := w.Close(); err != nil {
+		fail(fmt.Errorf("failed to close writer: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	if _, _, err := readReply(smtpClient.Text, config); err != nil {
+		fail(fmt.Errorf("failed to close writer: %w", wrapSMTPError(smtpClient, err)))
+	}
+}
+
+// sessionBroken 判断投递失败后会话能否继续用于下一次投递
+// 失败的投递已发送RSET（见fail）；服务器返回421、连接超时，或随后的NOOP检查失败时会话不能继续使用
+func sessionBroken(smtpClient *smtp.Client, err error) bool {
+	if err == nil {
+		return false
+	}
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) && smtpErr.Code == 421 {
+		return true
+	}
+	return isTimeout(err) || smtpClient.Noop() != nil
+}
+
+// checkRejections 被拒绝的收件人超过MaxRejectedRecipients或MaxRejectedRatio时返回ErrTooManyRejections
+func (m *Email) checkRejections(rejected, total int) error {
+	if rejected == 0 {
+		return nil
+	}
+	if (m.MaxRejectedRecipients > 0 && rejected > m.MaxRejectedRecipients) ||
+		(m.MaxRejectedRatio > 0 && float64(rejected) > m.MaxRejectedRatio*float64(total)) {
+		return fmt.Errorf("%w: %d of %d recipients rejected (%.0f%%)", ErrTooManyRejections, rejected, total, 100*float64(rejected)/float64(total))
 	}
 	return nil
 }
 
+// isTimeout 判断错误是否为连接超时
+// 超时后写入缓冲区会保留错误，继续发送RSET将使随后的QUIT也无法发出
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// wireSize 返回邮件在DATA中传输的字节数（不含点转义和结束标记）：
+// 单独的LF按CRLF计算，内容不以换行结尾时加上补充的CRLF，与textproto.DotWriter一致
+func wireSize(message []byte) int {
+	size := len(message) + bytes.Count(message, []byte("\n")) - bytes.Count(message, []byte("\r\n"))
+	if len(message) > 0 && message[len(message)-1] != '\n' {
+		size += 2
+	}
+	return size
+}
+
+// mailParams 生成MAIL FROM命令的参数，与标准库Mail方法保持一致
+// 服务器声明SIZE扩展时附加邮件大小（RFC 1870），使服务器在DATA之前拒绝过大的邮件；
+// 配置要求REQUIRETLS时附加REQUIRETLS参数，dial已确认服务器支持；binary为true时声明BODY=BINARYMIME
+func mailParams(smtpClient *smtp.Client, config *ConfigMapper, size int, binary bool) string {
+	params := ""
+	if binary {
+		params += " BODY=BINARYMIME"
+	} else if ok, _ := smtpClient.Extension("8BITMIME"); ok {
+		params += " BODY=8BITMIME"
+	}
+	if ok, _ := smtpClient.Extension("SIZE"); ok {
+		params += " SIZE=" + strconv.Itoa(size)
+	}
+	if ok, _ := smtpClient.Extension("SMTPUTF8"); ok {
+		params += " SMTPUTF8"
+	}
+	if config.RequireTLS {
+		params += " REQUIRETLS"
+	}
+	return params
+}
+
+// pipeline 使用PIPELINING扩展（RFC 2920）投递邮件
+// MAIL FROM、所有RCPT TO和DATA命令连续发送，然后按顺序读取响应，减少往返次数
+func (m *Email) pipeline(smtpClient *smtp.Client, config *ConfigMapper, from string, results []*SendResult, message []byte) {
+	text := smtpClient.Text
+	fail := func(err error) {
+		for _, result := range results {
+			if result.Err == nil {
+				result.Err = err
+			}
+		}
+		if !isTimeout(err) {
+			_ = smtpClient.Reset()
+		}
+	}
+
+	lines := []string{from}
+	for _, result := range results {
+		lines = append(lines, result.Recipient.Address)
+	}
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			fail(errors.New("smtp: A line must not contain CR or LF"))
+			return
+		}
+	}
+
+	// 连续发送命令，不等待响应
+	mailID, err := text.Cmd("MAIL FROM:<%s>%s", from, mailParams(smtpClient, config, wireSize(message), false))
+	if err != nil {
+		fail(fmt.Errorf("failed to set sender: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	rcptIDs := make([]uint, len(results))
+	for i, result := range results {
+		if rcptIDs[i], err = text.Cmd("RCPT TO:<%s>", result.Recipient.Address); err != nil {
+			fail(fmt.Errorf("failed to set recipient: %w", wrapSMTPError(smtpClient, err)))
+			return
+		}
+	}
+	// 限制被拒绝的收件人时需要先读取RCPT的响应，DATA不加入流水线
+	limited := m.MaxRejectedRecipients > 0 || m.MaxRejectedRatio > 0
+	var dataID uint
+	if !limited {
+		if dataID, err = text.Cmd("DATA"); err != nil {
+			fail(fmt.Errorf("failed to send data: %w", wrapSMTPError(smtpClient, err)))
+			return
+		}
+	}
+
+	// 按发送顺序读取响应
+	read := func(id uint) (string, error) {
+		text.StartResponse(id)
+		defer text.EndResponse(id)
+		code, msg, err := readReply(text, config)
+		return fmt.Sprintf("%d %s", code, msg), err
+	}
+	_, mailErr := read(mailID)
+	accepted := 0
+	for i, result := range results {
+		response, err := read(rcptIDs[i])
+		switch {
+		case mailErr != nil:
+			result.Err = fmt.Errorf("failed to set sender: %w", wrapSMTPError(smtpClient, mailErr))
+		case err != nil:
+			result.Err = fmt.Errorf("failed to set recipient: %w", wrapSMTPError(smtpClient, err))
+		default:
+			result.Response = response
+			if result.Alias && m.Logf != nil {
+				m.Logf("alias %s accepted: %s", result.Recipient.Address, response)
+			}
+			accepted++
+		}
+	}
+	if limited {
+		if accepted == 0 {
+			fail(nil)
+			return
+		}
+		if err = m.checkRejections(len(results)-accepted, len(results)); err != nil {
+			fail(err)
+			return
+		}
+		if dataID, err = text.Cmd("DATA"); err != nil {
+			fail(fmt.Errorf("failed to send data: %w", wrapSMTPError(smtpClient, err)))
+			return
+		}
+	}
+	text.StartResponse(dataID)
+	_, _, err = text.ReadResponse(354)
+	text.EndResponse(dataID)
+	if err != nil {
+		fail(fmt.Errorf("failed to send data: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	if accepted == 0 {
+		// 服务器接受了DATA但没有有效收件人，发送空内容结束本次投递
+		w := text.DotWriter()
+		_ = w.Close()
+		_, _, _ = text.ReadResponse(250)
+		fail(nil)
+		return
+	}
+
+	w := text.DotWriter()
+	if _, err = w.Write(message); err != nil {
+		fail(fmt.Errorf("failed to write message: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	if err = w.Close(); err != nil {
+		fail(fmt.Errorf("failed to close writer: %w", wrapSMTPError(smtpClient, err)))
+		return
+	}
+	if _, _, err = readReply(text, config); err != nil {
+		fail(fmt.Errorf("failed to close writer: %w", wrapSMTPError(smtpClient, err)))
+	}
+}
+
+// sendMail 建立连接并向单个收件人投递邮件，投递失败时按Retry.TransactionAttempts重新连接并重试
+func (m *Email) sendMail(config *ConfigMapper, from string, result *SendResult, message []byte) {
+	ctx := context.Background()
+	attempts := max(m.Retry.TransactionAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		sess, release, err := m.openSession(ctx, config)
+		if err != nil {
+			result.Err = err
+			return
+		}
+		result.Reused = sess.begin()
+		m.deliver(sess.client, config, from, []*SendResult{result}, message, true, false)
+		if err := release(); err != nil && result.Err == nil {
+			// 邮件已被服务器接受，QUIT失败不重试
+			result.Err = wrapSentinel(ErrQuitFailed, "failed to quit: %v", err)
+			return
+		}
+		if result.Err == nil || attempt >= attempts || !retryable(result.Err) || !m.Retry.wait(ctx) {
+			return
+		}
+		result.Err, result.Response, result.Host, result.Port, result.Reused = nil, "", "", 0, false
+	}
+}
+
+// deliver 使用配置的信封发件人投递邮件，from为From头的地址；开启VERP时每个收件人单独完成一次投递
+// archive为true时同时密送一份到归档地址，同一封邮件分多次投递时只应在其中一次设置；raw见transaction
+func (m *Email) deliver(smtpClient *smtp.Client, config *ConfigMapper, from string, results []*SendResult, message []byte, archive, raw bool) {
+	for _, result := range results {
+		result.Size = len(message)
+		result.Host, result.Port = config.Host, config.Port
+	}
+	var archived *SendResult
+	if archive {
+		archived = m.archiveRecipient(config, results)
+	}
+	if !m.VERP {
+		if archived != nil {
+			results = append(results[:len(results):len(results)], archived)
+		}
+		m.transaction(smtpClient, config, m.envelopeSender(config, from, ""), results, message, raw)
+	} else {
+		for _, result := range results {
+			m.transaction(smtpClient, config, m.envelopeSender(config, from, result.Recipient.Address), []*SendResult{result}, message, raw)
+		}
+		// 归档副本使用未编码的退信地址，避免退信被误认为来自某个收件人
+		if archived != nil {
+			m.transaction(smtpClient, config, m.envelopeSender(config, from, ""), []*SendResult{archived}, message, raw)
+		}
+	}
+	if archived != nil && archived.Err != nil && m.Logf != nil {
+		m.Logf("archive copy to %s failed: %v", archived.Recipient.Address, archived.Err)
+	}
+}
+
+// archiveRecipient 返回归档地址对应的收件人，未配置归档地址或归档地址已是收件人时返回nil
+func (m *Email) archiveRecipient(config *ConfigMapper, results []*SendResult) *SendResult {
+	address := config.ArchiveBCC
+	if address == "" {
+		address = m.ArchiveBCC
+	}
+	if address == "" {
+		return nil
+	}
+	for _, result := range results {
+		if strings.EqualFold(result.Recipient.Address, address) {
+			return nil
+		}
+	}
+	return &SendResult{Recipient: mail.Address{Address: address}}
+}
+
+// envelopeSender 生成MAIL FROM使用的信封发件人，from为From头的地址
+// 配置的EnvelopeSenders中有与from同域名的地址时使用该地址，否则使用BounceAddress或Username；
+// 开启VERP时将收件人编码进退信地址的本地部分：bounce@mydomain.com与user@example.com
+// 生成bounce+user=example.com@mydomain.com
+func (m *Email) envelopeSender(config *ConfigMapper, from, recipient string) string {
+	sender := m.BounceAddress
+	if sender == "" {
+		sender = config.Username
+	}
+	if domain := domainOf(from); domain != "" {
+		for _, aligned := range config.EnvelopeSenders {
+			if strings.EqualFold(domainOf(aligned), domain) {
+				sender = aligned
+				break
+			}
+		}
+	}
+	if !m.VERP || recipient == "" {
+		return sender
+	}
+	at := strings.LastIndex(sender, "@")
+	if at < 0 {
+		return sender
+	}
+	if i := strings.LastIndex(recipient, "@"); i >= 0 {
+		recipient = recipient[:i] + "=" + recipient[i+1:]
+	}
+	return sender[:at] + "+" + recipient + sender[at:]
+}
+
+// Envelope 返回向to发送时使用的信封地址，不建立连接，用于排查路由和SPF对齐问题
+// mailFrom为MAIL FROM的地址（BounceAddress、VERP编码等处理之后），rcptTo为RCPT TO的地址（RewriteRecipient改写之后）；
+// 收件人无法路由、配置无效或From域名被RejectMisalignedFrom拒绝时返回与发送时相同的错误
+func (m *Email) Envelope(fromName string, to mail.Address) (mailFrom string, rcptTo string, err error) {
+	results := make([]SendResult, 1)
+	toList := m.rewriteRecipients([]mail.Address{to})
+	configs, _ := m.route(toList, results, nil)
+	if results[0].Err != nil {
+		return "", "", results[0].Err
+	}
+	config := configs[0]
+	from := fromAddress(config, fromName)
+	if err = m.checkFrom(config, from); err != nil {
+		return "", "", err
+	}
+	rcptTo = toList[0].Address
+	return m.envelopeSender(config, from.Address, rcptTo), rcptTo, nil
+}
+
+// rewriteRecipients 使用RewriteRecipient改写收件人，未设置时原样返回
+func (m *Email) rewriteRecipients(toList []mail.Address) []mail.Address {
+	if m.RewriteRecipient == nil {
+		return toList
+	}
+	rewritten := make([]mail.Address, len(toList))
+	for i, to := range toList {
+		rewritten[i] = m.RewriteRecipient(to)
+	}
+	return rewritten
+}
+
+// isAlias 判断地址是否为配置的分发列表别名
+func (m *Email) isAlias(address string) bool {
+	for _, alias := range m.Aliases {
+		if strings.EqualFold(alias, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendResult 单个收件人的发送结果
+type SendResult struct {
+	Recipient mail.Address
+	Err       error  // 发送成功时为nil
+	Alias     bool   // 收件人是否为分发列表别名
+	Response  string // 服务器对RCPT TO的响应，如别名展开信息
+	Size      int    // 序列化后的邮件大小（字节），邮件未能构建时为0
+	Host      string // 实际投递所经中继的主机，未能建立会话时为空
+	Port      int    // 实际投递所经中继的端口，未能建立会话时为0
+	Reused    bool   // 投递是否使用了已投递过邮件的连接（连接池中的空闲连接或ReuseConnection共享的连接），为false时为新建立的连接
+}
+
 // Send 发送邮件
-// isHTML: 是否发送HTML格式邮件，默认false（纯文本）
+// isHTML: 是否发送HTML格式邮件，未传入时使用DefaultHTML，默认false（纯文本）
 func (m *Email) Send(fromName string, toList []mail.Address, subject, content string, isHTML ...bool) []error {
+	var errs []error
+	for _, result := range m.send(fromName, toList, staticContent(subject, content), m.optionsOf(isHTML), nil) {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+// SendWithOptions 使用指定选项发送邮件，返回与toList一一对应的结果
+func (m *Email) SendWithOptions(fromName string, toList []mail.Address, subject, content string, opts SendOptions) []SendResult {
+	return m.send(fromName, toList, staticContent(subject, content), opts, nil)
+}
+
+// SendVia 使用指定的配置向所有收件人发送邮件，不按收件人域名选择配置
+// 配置无效时所有收件人都以配置错误失败；其他行为与SendWithOptions相同
+func (m *Email) SendVia(config *ConfigMapper, fromName string, toList []mail.Address, subject, content string, opts SendOptions) []SendResult {
+	if err := config.Validate(); err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		if len(toList) == 0 {
+			return []SendResult{{Err: err}}
+		}
+		return failAll(toList, err)
+	}
+	opts.via = config
+	return m.send(fromName, toList, staticContent(subject, content), opts, nil)
+}
+
+// SendSummary 发送邮件并统计成功和失败的数量
+// 返回的results与toList一一对应
+func (m *Email) SendSummary(fromName string, toList []mail.Address, subject, content string, isHTML ...bool) (sent int, failed int, results []SendResult) {
+	results = m.send(fromName, toList, staticContent(subject, content), m.optionsOf(isHTML), nil)
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		} else {
+			sent++
+		}
+	}
+	return sent, failed, results
+}
+
+// checkConfig 验证配置，结果缓存在checked中，同一次发送中每个配置只验证一次
+func checkConfig(config *ConfigMapper, checked map[*ConfigMapper]error) error {
+	err, ok := checked[config]
+	if !ok {
+		err = config.Validate()
+		checked[config] = err
+	}
+	return err
+}
+
+// route 按配置对收件人分组，无法路由的收件人直接记录错误
+// 返回配置的出现顺序，以及每个配置对应的收件人在toList中的位置
+// via不为nil时所有收件人都使用via，不按域名选择配置
+func (m *Email) route(toList []mail.Address, results []SendResult, via *ConfigMapper) ([]*ConfigMapper, map[*ConfigMapper][]int) {
+	var configs []*ConfigMapper
+	groups := make(map[*ConfigMapper][]int)
+	invalid := make(map[*ConfigMapper]error)
+	for i, addr := range toList {
+		results[i].Recipient = addr
+		results[i].Alias = m.isAlias(addr.Address)
+		if err := m.checkRecipient(addr.Address); err != nil {
+			results[i].Err = err
+			continue
+		}
+		config, ok := via, via != nil
+		if via == nil {
+			config, ok = m.GetMapper(addr.Address)
+		}
+		if !ok {
+			if m.StrictRouting {
+				results[i].Err = wrapSentinel(ErrNoConfig, "email: no route for domain %q", domainOf(addr.Address))
+			} else {
+				results[i].Err = fmt.Errorf("%w for %s", ErrNoConfig, addr.Address)
+			}
+			continue
+		}
+		if err := checkConfig(config, invalid); err != nil {
+			results[i].Err = fmt.Errorf("%w for %s: %w", ErrInvalidConfig, addr.Address, err)
+			continue
+		}
+		if _, ok := groups[config]; !ok {
+			configs = append(configs, config)
+		}
+		groups[config] = append(groups[config], i)
+	}
+	return configs, groups
+}
+
+// checkRecipient 按AllowedDomains和DeniedDomains检查收件人的域名
+func (m *Email) checkRecipient(address string) error {
+	domain := domainOf(address)
+	for _, denied := range m.DeniedDomains {
+		if strings.EqualFold(domain, denied) {
+			return wrapSentinel(ErrRecipientNotAllowed, "email: recipient domain %q is denied", domain)
+		}
+	}
+	if len(m.AllowedDomains) == 0 {
+		return nil
+	}
+	for _, allowed := range m.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return nil
+		}
+	}
+	return wrapSentinel(ErrRecipientNotAllowed, "email: recipient domain %q is not in the allowed domains", domain)
+}
+
+// Unroutable RoutePlan中无法路由（没有配置、配置无效或域名不被允许）的收件人使用的键
+const Unroutable = "unroutable"
+
+// RoutePlan 按选中的配置对收件人分组而不发送，用于容量规划
+// 键为配置的"主机:端口"，无法路由的收件人在Unroutable下；路由按RewriteRecipient改写后的地址进行，
+// 返回的地址为改写前的地址
+func (m *Email) RoutePlan(toList []mail.Address) map[string][]mail.Address {
+	plan := make(map[string][]mail.Address)
+	results := make([]SendResult, len(toList))
+	configs, groups := m.route(m.rewriteRecipients(toList), results, nil)
+	for i := range results {
+		if results[i].Err != nil {
+			plan[Unroutable] = append(plan[Unroutable], toList[i])
+		}
+	}
+	for _, config := range configs {
+		relay := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+		for _, i := range groups[config] {
+			plan[relay] = append(plan[relay], toList[i])
+		}
+	}
+	return plan
+}
+
+// checkRecipientCount 收件人总数超过MaxRecipientsPerSend时返回ErrTooManyRecipients
+func (m *Email) checkRecipientCount(n int) error {
+	if m.MaxRecipientsPerSend > 0 && n > m.MaxRecipientsPerSend {
+		return fmt.Errorf("%w: %d recipients exceed MaxRecipientsPerSend of %d, nothing sent", ErrTooManyRecipients, n, m.MaxRecipientsPerSend)
+	}
+	return nil
+}
+
+// Preflight 检查所有收件人是否都能找到对应配置，返回列出无法路由收件人的错误
+func (m *Email) Preflight(toList []mail.Address) error {
+	var unroutable []string
+	for _, addr := range toList {
+		if _, ok := m.GetMapper(addr.Address); !ok {
+			unroutable = append(unroutable, addr.Address)
+		}
+	}
+	if len(unroutable) > 0 {
+		return fmt.Errorf("email: unroutable recipients: %s", strings.Join(unroutable, ", "))
+	}
+	return nil
+}
+
+// preflight 开启PreflightRouting时检查路由，失败则所有收件人都记录该错误
+func (m *Email) preflight(toList []mail.Address) ([]SendResult, bool) {
+	if !m.PreflightRouting {
+		return nil, true
+	}
+	err := m.Preflight(toList)
+	if err == nil {
+		return nil, true
+	}
+	results := make([]SendResult, len(toList))
+	for i, addr := range toList {
+		results[i] = SendResult{Recipient: addr, Alias: m.isAlias(addr.Address), Err: err}
+	}
+	return results, false
+}
+
+// renderFunc 为收件人生成邮件主题和正文
+type renderFunc func(to mail.Address) (subject, content string, err error)
+
+// staticContent 所有收件人使用相同的主题和正文
+func staticContent(subject, content string) renderFunc {
+	return func(mail.Address) (string, string, error) {
+		return subject, content, nil
+	}
+}
+
+// limiter 限制同时进行的连接数，为nil时不限制
+type limiter chan struct{}
+
+// newLimiter 创建最多允许n个并发的限制器，n<=0时不限制
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+func (l limiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// send 为每个收件人单独构建邮件并并发发送，返回与toList一一对应的结果
+// onResult不为nil时，每个收件人的结果确定后立即以该结果调用onResult
+func (m *Email) send(fromName string, toList []mail.Address, render renderFunc, opts SendOptions, onResult func(SendResult)) []SendResult {
+	emit := func(result *SendResult) {
+		if onResult != nil {
+			onResult(*result)
+		}
+	}
 	if len(toList) == 0 {
-		return []error{errors.New("gomail: no recipients")}
+		results := []SendResult{{Err: ErrNoRecipients}}
+		emit(&results[0])
+		return results
 	}
-	var errs []error
-	var mutex sync.Mutex
+	if err := m.checkRecipientCount(len(toList)); err != nil {
+		results := failAll(toList, err)
+		for i := range results {
+			emit(&results[i])
+		}
+		return results
+	}
+	originals := toList
+	toList = m.rewriteRecipients(toList)
+	if opts.via == nil {
+		if results, ok := m.preflight(toList); !ok {
+			for i := range results {
+				emit(&results[i])
+			}
+			return results
+		}
+	}
+	results := make([]SendResult, len(toList))
+	configs, groups := m.route(toList, results, opts.via)
+	for i := range results {
+		if results[i].Err != nil {
+			emit(&results[i])
+		}
+	}
+
+	// build 为第i个收件人渲染并构建邮件，模板按改写前的收件人渲染
+	build := func(from mail.Address, i int) ([]byte, error) {
+		subject, content, err := render(originals[i])
+		if err != nil {
+			return nil, err
+		}
+		header := []mail.Address{toList[i]}
+		if len(opts.HeaderTo) > 0 {
+			header = opts.HeaderTo
+		}
+		msg := newMessage(from, header, subject, content, opts)
+		if opts.HeadersFor != nil {
+			for key, value := range opts.HeadersFor(originals[i]) {
+				msg.Header.Set(key, value)
+			}
+		}
+		setOriginalTo(msg, originals[i:i+1], toList[i:i+1])
+		if m.BeforeSend != nil {
+			if err := m.BeforeSend(context.Background(), toList[i], msg); err != nil {
+				return nil, err
+			}
+		}
+		return m.prepare(msg, toList[i:i+1])
+	}
+
 	var wg sync.WaitGroup
+	sem := newLimiter(m.MaxConcurrency)
+	for _, config := range configs {
+		indexes := groups[config]
+		config = m.applyTLS(opts.TLS, config)
+		from := fromAddress(config, fromName)
+		if err := m.checkFrom(config, from); err != nil {
+			for _, i := range indexes {
+				results[i].Err = err
+				emit(&results[i])
+			}
+			continue
+		}
 
-	// 确定邮件格式
-	html := false
-	if len(isHTML) > 0 {
-		html = isHTML[0]
+		// 复用连接时同一配置的收件人在一个会话中依次投递
+		if m.ReuseConnection {
+			wg.Add(1)
+			go func(config *ConfigMapper) {
+				defer wg.Done()
+				sem.acquire()
+				defer sem.release()
+
+				// 投递失败后会话不能继续使用时关闭连接，为后续收件人重新建立连接
+				var sess *session
+				var release func() error
+				defer func() {
+					if sess != nil {
+						_ = release()
+					}
+				}()
+				for n, i := range indexes {
+					message, err := build(from, i)
+					if err != nil {
+						results[i].Err = err
+						emit(&results[i])
+						continue
+					}
+					if sess == nil {
+						if sess, release, err = m.openSession(context.Background(), config); err != nil {
+							for _, i := range indexes[n:] {
+								if results[i].Err == nil {
+									results[i].Err = err
+								}
+								emit(&results[i])
+							}
+							return
+						}
+					}
+					results[i].Reused = sess.begin()
+					m.deliver(sess.client, config, from.Address, []*SendResult{&results[i]}, message, true, false)
+					if sessionBroken(sess.client, results[i].Err) {
+						_ = sess.close()
+						sess = nil
+					}
+					emit(&results[i])
+				}
+			}(config)
+			continue
+		}
+
+		// 并发发送邮件
+		for _, i := range indexes {
+			wg.Add(1)
+			go func(config *ConfigMapper, i int) {
+				defer wg.Done()
+				defer emit(&results[i])
+				message, err := build(from, i)
+				if err != nil {
+					results[i].Err = err
+					return
+				}
+				sem.acquire()
+				defer sem.release()
+				m.sendMail(config, from.Address, &results[i], message)
+			}(config, i)
+		}
 	}
 
-	// 设置内容类型
-	contentType := "Content-Type: text/plain; charset=UTF-8"
-	if html {
-		contentType = "Content-Type: text/html; charset=UTF-8"
+	// 等待所有邮件发送完成
+	wg.Wait()
+	return results
+}
+
+// SendStream 与SendWithOptions相同的发送方式，每个收件人的结果确定后立即写入返回的通道
+// 通道的缓冲区可以容纳全部结果，消费缓慢不会阻塞发送；全部发送完成后通道被关闭
+func (m *Email) SendStream(fromName string, toList []mail.Address, subject, content string, opts SendOptions) <-chan SendResult {
+	stream := make(chan SendResult, max(len(toList), 1))
+	m.begin()
+	go func() {
+		defer m.end()
+		defer close(stream)
+		m.send(fromName, toList, staticContent(subject, content), opts, func(result SendResult) {
+			stream <- result
+		})
+	}()
+	return stream
+}
+
+// SendGroup 向所有收件人发送同一封邮件，To头中列出全部收件人
+// 使用同一配置的收件人共享一个连接，超过配置的MaxRecipientsPerMessage时分批投递
+// 返回结果与toList一一对应
+func (m *Email) SendGroup(fromName string, toList []mail.Address, subject, content string, isHTML ...bool) []SendResult {
+	if len(toList) == 0 {
+		return []SendResult{{Err: ErrNoRecipients}}
 	}
+	if err := m.checkRecipientCount(len(toList)); err != nil {
+		return failAll(toList, err)
+	}
+	originals := toList
+	toList = m.rewriteRecipients(toList)
+	if results, ok := m.preflight(toList); !ok {
+		return results
+	}
+	results := make([]SendResult, len(toList))
+	configs, groups := m.route(toList, results, nil)
 
-	// 并发发送邮件
-	for _, toAddr := range toList {
+	opts := m.optionsOf(isHTML)
+	var wg sync.WaitGroup
+	sem := newLimiter(m.MaxConcurrency)
+	for _, config := range configs {
 		wg.Add(1)
-		go func(addr mail.Address) {
+		go func(config *ConfigMapper, indexes []int) {
 			defer wg.Done()
+			sem.acquire()
+			defer sem.release()
 
-			config, ok := m.GetMapper(addr.Address)
-			if !ok {
-				return
+			from := fromAddress(config, fromName)
+			msg := newMessage(from, toList, subject, content, opts)
+			setOriginalTo(msg, originals, toList)
+			if m.BeforeSend != nil {
+				// 被BeforeSend跳过的收件人不加入投递
+				allowed := indexes[:0:0]
+				for _, i := range indexes {
+					if err := m.BeforeSend(context.Background(), toList[i], msg); err != nil {
+						results[i].Err = err
+						continue
+					}
+					allowed = append(allowed, i)
+				}
+				if indexes = allowed; len(indexes) == 0 {
+					return
+				}
 			}
-
-			from := mail.Address{
-				Name:    fromName,
-				Address: config.Username,
+			envelope := make([]mail.Address, len(indexes))
+			for j, i := range indexes {
+				envelope[j] = toList[i]
 			}
-			message := buildMessage(from, addr, subject, contentType, content)
-			var err error
-
-			if !config.TLS {
-				// TLS=false时发送普通SMTP邮件
-				err = sendPlainMail(config, from, addr.Address, message)
-			} else {
-				// TLS=true时发送TLS加密邮件
-				err = sendTLSMail(config, from, addr, message)
+			message, err := m.prepare(msg, envelope)
+			if err == nil {
+				err = m.checkFrom(config, from)
 			}
-
 			if err != nil {
-				mutex.Lock()
-				errs = append(errs, err)
-				mutex.Unlock()
+				for _, i := range indexes {
+					results[i].Err = err
+				}
+				return
 			}
-		}(toAddr)
+
+			m.deliverGroup(context.Background(), config, from.Address, results, indexes, message, false)
+		}(config, groups[config])
 	}
 
-	// 等待所有邮件发送完成
 	wg.Wait()
-	return errs
+	return results
+}
+
+// deliverGroup 在一个连接上向results中indexes对应的收件人投递同一封邮件，from为From头的地址
+// 超过配置的MaxRecipientsPerMessage时分批投递；raw见transaction
+func (m *Email) deliverGroup(ctx context.Context, config *ConfigMapper, from string, results []SendResult, indexes []int, message []byte, raw bool) {
+	sess, release, err := m.openSession(ctx, config)
+	if err != nil {
+		for _, i := range indexes {
+			results[i].Err = err
+		}
+		return
+	}
+
+	// 按单次投递的收件人上限分批，每批发送同一封邮件
+	size := len(indexes)
+	if config.MaxRecipientsPerMessage > 0 {
+		size = config.MaxRecipientsPerMessage
+	}
+	for start := 0; start < len(indexes); start += size {
+		chunk := indexes[start:min(start+size, len(indexes))]
+		batch := make([]*SendResult, len(chunk))
+		reused := sess.begin()
+		for j, i := range chunk {
+			batch[j] = &results[i]
+			batch[j].Reused = reused
+		}
+		m.deliver(sess.client, config, from, batch, message, start == 0, raw)
+	}
+	if err := release(); err != nil {
+		for _, i := range indexes {
+			if results[i].Err == nil {
+				results[i].Err = wrapSentinel(ErrQuitFailed, "failed to quit: %v", err)
+			}
+		}
+	}
 }
@@ -1,8 +1,21 @@
 package email
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var configMapper = map[string]*ConfigMapper{
@@ -64,8 +77,11 @@ func TestEmail_EmptyRecipients(t *testing.T) {
 	if len(errs) == 0 {
 		t.Error("expected error for empty recipients, but got none")
 	}
-	if errs[0].Error() != "gomail: no recipients" {
-		t.Errorf("expected 'gomail: no recipients' error, got %v", errs[0])
+	if errs[0].Error() != "email: no recipients" {
+		t.Errorf("expected 'email: no recipients' error, got %v", errs[0])
+	}
+	if !errors.Is(errs[0], ErrNoRecipients) {
+		t.Errorf("expected ErrNoRecipients, got %v", errs[0])
 	}
 }
 
@@ -110,3 +126,1986 @@ func TestEmail_ConfigurationValidation(t *testing.T) {
 		t.Error("expected Email instance even with invalid configuration")
 	}
 }
+
+// TestEmail_OpportunisticTLS tests upgrading a plaintext connection via STARTTLS
+func TestEmail_OpportunisticTLS(t *testing.T) {
+	server := (&mockServer{extensions: []string{"STARTTLS", "AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.OpportunisticTLS = true
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	errs := email.Send("测试发件人", []mail.Address{{Name: "收件人", Address: "rcpt@example.com"}}, "测试主题", "测试内容")
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if server.tlsUpgrades() != 1 {
+		t.Fatalf("expected 1 STARTTLS upgrade, got %d", server.tlsUpgrades())
+	}
+	// STARTTLS必须在AUTH之前完成
+	got := strings.Join(verbs(server.commands()), " ")
+	if !strings.HasPrefix(got, "EHLO STARTTLS EHLO AUTH MAIL RCPT DATA") {
+		t.Errorf("unexpected command sequence: %s", got)
+	}
+}
+
+// TestEmail_SendGroupChunking tests splitting recipients by MaxRecipientsPerMessage
+func TestEmail_SendGroupChunking(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.MaxRecipientsPerMessage = 2
+
+	var toList []mail.Address
+	for i := 0; i < 5; i++ {
+		toList = append(toList, mail.Address{Address: fmt.Sprintf("user%d@example.com", i)})
+	}
+	email := New(map[string]*ConfigMapper{"default": config})
+	results := email.SendGroup("测试发件人", toList, "测试主题", "测试内容")
+	if len(results) != len(toList) {
+		t.Fatalf("expected %d results, got %d", len(toList), len(results))
+	}
+	for i, result := range results {
+		if result.Recipient != toList[i] || result.Err != nil {
+			t.Errorf("unexpected result %d: %+v", i, result)
+		}
+	}
+
+	var data, rcpt int
+	for _, verb := range verbs(server.commands()) {
+		switch verb {
+		case "DATA":
+			data++
+		case "RCPT":
+			rcpt++
+		}
+	}
+	if data != 3 || rcpt != 5 {
+		t.Errorf("expected 3 DATA and 5 RCPT commands, got %d and %d", data, rcpt)
+	}
+	if server.connections() != 1 {
+		t.Errorf("expected 1 connection, got %d", server.connections())
+	}
+}
+
+// TestEmail_SendSummary tests counting successful and failed recipients
+func TestEmail_SendSummary(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if strings.HasPrefix(cmd, "RCPT") && strings.Contains(cmd, "unknown@") {
+				return "550 no such user"
+			}
+			return ""
+		},
+	}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	sent, failed, results := email.SendSummary("测试发件人", []mail.Address{
+		{Address: "user1@example.com"},
+		{Address: "unknown@example.com"},
+		{Address: "user2@example.com"},
+	}, "测试主题", "测试内容")
+	if sent != 2 || failed != 1 {
+		t.Errorf("expected 2 sent and 1 failed, got %d and %d", sent, failed)
+	}
+	if len(results) != 3 || results[1].Err == nil || results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+// TestEmail_DefaultFromName tests falling back to the configured FromName
+func TestEmail_DefaultFromName(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.FromName = "系统通知"
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	if errs := email.Send("", []mail.Address{{Address: "rcpt@example.com"}}, "测试主题", "测试内容"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(received))
+	}
+	want := "From: " + (&mail.Address{Name: "系统通知", Address: config.Username}).String()
+	if !strings.Contains(received[0], want+"\r\n") {
+		t.Errorf("expected header %q in message:\n%s", want, received[0])
+	}
+	if !strings.Contains(want, "=?utf-8?") {
+		t.Errorf("expected RFC 2047 encoded name, got %q", want)
+	}
+}
+
+// TestEmail_PlainCommandSequence tests the exact command flow on the plaintext path
+func TestEmail_PlainCommandSequence(t *testing.T) {
+	// 未开启OpportunisticTLS时，即使服务器支持STARTTLS也不应升级
+	server := (&mockServer{extensions: []string{"STARTTLS", "AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.HELOName = "client.example.com"
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	if errs := email.Send("测试发件人", []mail.Address{{Address: "rcpt@example.com"}}, "测试主题", "测试内容"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := []string{
+		"EHLO client.example.com",
+		"AUTH LOGIN",
+		"MAIL FROM:<sender@example.com>",
+		"RCPT TO:<rcpt@example.com>",
+		"DATA",
+		"QUIT",
+	}
+	if got := server.commands(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected command sequence:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+// TestEmail_Timeout tests that a silent server fails within the configured timeout
+func TestEmail_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		// 接受连接但从不发送欢迎信息
+		if conn, err := ln.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+
+	email := New(map[string]*ConfigMapper{"default": {
+		Host:     "127.0.0.1",
+		Port:     ln.Addr().(*net.TCPAddr).Port,
+		Username: "sender@example.com",
+		Password: "secret",
+		Timeout:  200 * time.Millisecond,
+	}})
+	start := time.Now()
+	errs := email.Send("测试发件人", []mail.Address{{Address: "rcpt@example.com"}}, "测试主题", "测试内容")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected send to fail within timeout, took %v", elapsed)
+	}
+	_ = (<-conns).Close()
+}
+
+// TestEmail_AliasRecipient tests reporting an alias recipient with the server response
+func TestEmail_AliasRecipient(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if cmd == "RCPT TO:<team@example.com>" {
+				return "250 2.1.5 team expanded to 3 members"
+			}
+			return ""
+		},
+	}).start(t)
+
+	var logs []string
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.SuppressInsecureWarning = true
+	email.Aliases = []string{"team@example.com"}
+	email.Logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	_, failed, results := email.SendSummary("测试发件人", []mail.Address{{Address: "team@example.com"}}, "测试主题", "测试内容")
+	if failed != 0 {
+		t.Fatalf("unexpected failures: %+v", results)
+	}
+	if !results[0].Alias || results[0].Recipient.Address != "team@example.com" {
+		t.Errorf("expected alias recipient to be preserved, got %+v", results[0])
+	}
+	if results[0].Response != "250 2.1.5 team expanded to 3 members" {
+		t.Errorf("unexpected response: %q", results[0].Response)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "expanded to 3 members") {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+}
+
+// TestEmail_ReuseConnection tests sharing one session for recipients routed to the same config
+func TestEmail_ReuseConnection(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.ReuseConnection = true
+	errs := email.Send("测试发件人", []mail.Address{
+		{Address: "user1@a.example.com"},
+		{Address: "user2@a.example.com"},
+		{Address: "user1@b.example.com"},
+		{Address: "user2@b.example.com"},
+	}, "测试主题", "测试内容")
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if server.connections() != 1 {
+		t.Errorf("expected 1 connection, got %d", server.connections())
+	}
+	if received := server.received(); len(received) != 4 {
+		t.Errorf("expected 4 messages, got %d", len(received))
+	}
+}
+
+// TestEmail_StrictRouting tests that unmatched domains error instead of using default
+func TestEmail_StrictRouting(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{
+		"default":     server.config(false),
+		"example.com": server.config(false),
+	})
+	email.StrictRouting = true
+	if _, ok := email.GetMapper("user@unknown.com"); ok {
+		t.Error("expected no fallback to default in strict mode")
+	}
+	_, failed, results := email.SendSummary("测试发件人", []mail.Address{
+		{Address: "user@example.com"},
+		{Address: "user@unknown.com"},
+	}, "测试主题", "测试内容")
+	if failed != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "no route for domain") {
+		t.Errorf("expected no route error, got %v", results[1].Err)
+	}
+	if !errors.Is(results[1].Err, ErrNoConfig) {
+		t.Errorf("expected ErrNoConfig, got %v", results[1].Err)
+	}
+	if received := server.received(); len(received) != 1 {
+		t.Errorf("expected 1 message, got %d", len(received))
+	}
+}
+
+// TestEmail_PreflightRouting tests aborting the whole send when a recipient is unroutable
+func TestEmail_PreflightRouting(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"example.com": server.config(false)})
+	email.PreflightRouting = true
+	toList := []mail.Address{
+		{Address: "user@example.com"},
+		{Address: "user@unknown.com"},
+	}
+	if err := email.Preflight(toList); err == nil || !strings.Contains(err.Error(), "user@unknown.com") {
+		t.Errorf("expected preflight error listing the unroutable recipient, got %v", err)
+	}
+	errs := email.Send("测试发件人", toList, "测试主题", "测试内容")
+	if len(errs) == 0 {
+		t.Fatal("expected errors for unroutable recipient")
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connections, got %d", server.connections())
+	}
+}
+
+// TestEmail_Pipelining tests sending MAIL, RCPT and DATA before reading responses
+func TestEmail_Pipelining(t *testing.T) {
+	// 服务器在收到DATA之前不响应MAIL和RCPT，未使用流水线的客户端会超时
+	server := (&mockServer{
+		extensions: []string{"PIPELINING", "AUTH LOGIN PLAIN"},
+		pipelined:  true,
+		reply: func(cmd string) string {
+			if cmd == "RCPT TO:<unknown@example.com>" {
+				return "550 no such user"
+			}
+			return ""
+		},
+	}).start(t)
+	config := server.config(false)
+	config.Timeout = 2 * time.Second
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	results := email.SendGroup("测试发件人", []mail.Address{
+		{Address: "user1@example.com"},
+		{Address: "unknown@example.com"},
+		{Address: "user2@example.com"},
+	}, "测试主题", "测试内容")
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("unexpected errors: %+v", results)
+	}
+	if results[1].Err == nil {
+		t.Error("expected rejected recipient to report an error")
+	}
+	got := strings.Join(verbs(server.commands()), " ")
+	if !strings.Contains(got, "MAIL RCPT RCPT RCPT DATA") {
+		t.Errorf("unexpected command sequence: %s", got)
+	}
+	if len(server.received()) != 1 {
+		t.Errorf("expected 1 message, got %d", len(server.received()))
+	}
+}
+
+// TestEmail_ConfigWarnings tests retrieving validation warnings without writing to stdout
+func TestEmail_ConfigWarnings(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	email := New(map[string]*ConfigMapper{
+		"default": {
+			Host:     "smtp.example.com",
+			Port:     465,
+			Username: "user@example.com",
+			Password: "password",
+		},
+		"example.org": {
+			Host:     "smtp.example.org",
+			Port:     0, // 无效端口
+			Username: "user@example.org",
+			Password: "password",
+		},
+	})
+	os.Stdout = stdout
+	_ = w.Close()
+	output, _ := io.ReadAll(r)
+
+	if len(output) > 0 {
+		t.Errorf("expected nothing written to stdout, got %q", output)
+	}
+	warnings := email.ConfigWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "example.org") {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+// TestEmail_MissingDefaultWarning tests flagging a configuration map without a default entry
+func TestEmail_MissingDefaultWarning(t *testing.T) {
+	config := &ConfigMapper{Host: "smtp.example.com", Port: 465, Username: "user@example.com", Password: "password"}
+	warnings := New(map[string]*ConfigMapper{"example.com": config, "example.org": config}).ConfigWarnings()
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrNoConfig) {
+		t.Fatalf("expected a missing default warning, got %v", warnings)
+	}
+	if want := "no default configuration: recipients outside example.com, example.org will fail"; warnings[0].Error() != want {
+		t.Errorf("expected %q, got %q", want, warnings[0].Error())
+	}
+
+	if warnings = New(map[string]*ConfigMapper{"default": config, "example.org": config}).ConfigWarnings(); len(warnings) != 0 {
+		t.Errorf("expected no warnings with a default configuration, got %v", warnings)
+	}
+}
+
+// TestEmail_EHLOAroundSTARTTLS tests re-issuing EHLO with the same name after STARTTLS
+func TestEmail_EHLOAroundSTARTTLS(t *testing.T) {
+	// 加密前只声明STARTTLS，PIPELINING仅在加密后声明，用于确认重新解析了服务器能力
+	server := (&mockServer{
+		extensions:    []string{"STARTTLS"},
+		tlsExtensions: []string{"AUTH LOGIN PLAIN", "PIPELINING"},
+		pipelined:     true,
+	}).start(t)
+	config := server.config(false)
+	config.OpportunisticTLS = true
+	config.HELOName = "client.example.com"
+	config.Timeout = 2 * time.Second
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	if errs := email.Send("测试发件人", []mail.Address{{Address: "rcpt@example.com"}}, "测试主题", "测试内容"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	cmds := server.commands()
+	if len(cmds) < 3 || cmds[0] != "EHLO client.example.com" || cmds[1] != "STARTTLS" || cmds[2] != "EHLO client.example.com" {
+		t.Errorf("expected EHLO, STARTTLS, EHLO with the same name, got %q", cmds)
+	}
+}
+
+// TestEmail_EHLOAfterImplicitTLS tests sending EHLO after the implicit TLS handshake
+func TestEmail_EHLOAfterImplicitTLS(t *testing.T) {
+	server := (&mockServer{implicitTLS: true, extensions: []string{"AUTH PLAIN"}}).start(t)
+	config := server.config(true)
+	config.HELOName = "client.example.com"
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	if errs := email.Send("测试发件人", []mail.Address{{Address: "rcpt@example.com"}}, "测试主题", "测试内容"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if cmds := server.commands(); len(cmds) == 0 || cmds[0] != "EHLO client.example.com" {
+		t.Errorf("expected EHLO with configured name first, got %q", cmds)
+	}
+}
+
+// TestEmail_SendStream tests receiving one streamed result per recipient
+func TestEmail_SendStream(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"example.com": server.config(false)})
+	email.MaxConcurrency = 2
+	toList := []mail.Address{
+		{Address: "user1@example.com"},
+		{Address: "user2@example.com"},
+		{Address: "user3@example.com"},
+		{Address: "user@unknown.com"}, // 无法路由
+	}
+	seen := make(map[string]bool)
+	failed := 0
+	for result := range email.SendStream("测试发件人", toList, "测试主题", "测试内容", SendOptions{}) {
+		if seen[result.Recipient.Address] {
+			t.Errorf("duplicate result for %s", result.Recipient.Address)
+		}
+		seen[result.Recipient.Address] = true
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if len(seen) != len(toList) {
+		t.Errorf("expected %d results, got %d", len(toList), len(seen))
+	}
+	if failed != 1 {
+		t.Errorf("expected 1 failure, got %d", failed)
+	}
+}
+
+// TestEmail_Middleware tests modifying and rejecting messages with middleware
+func TestEmail_Middleware(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Middleware = []func(*Message) error{
+		func(msg *Message) error {
+			msg.Body += "\n\n-- \nExample Corp"
+			msg.Header.Set("X-Campaign", "welcome")
+			return nil
+		},
+		func(msg *Message) error {
+			if msg.To[0].Address == "blocked@example.com" {
+				return errors.New("recipient blocked")
+			}
+			return nil
+		},
+	}
+	_, failed, results := email.SendSummary("测试发件人", []mail.Address{
+		{Address: "rcpt@example.com"},
+		{Address: "blocked@example.com"},
+	}, "测试主题", "hello")
+	if failed != 1 || results[1].Err == nil || results[1].Err.Error() != "recipient blocked" {
+		t.Fatalf("expected blocked recipient to be rejected by middleware, got %+v", results)
+	}
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(received))
+	}
+	if !strings.Contains(received[0], "hello\r\n\r\n-- \r\nExample Corp") {
+		t.Errorf("expected footer in message body:\n%s", received[0])
+	}
+	if !strings.Contains(received[0], "\r\nX-Campaign: welcome\r\n") {
+		t.Errorf("expected header added by middleware:\n%s", received[0])
+	}
+}
+
+// TestValidateSingleConfig_Username tests rejecting malformed username addresses
+func TestValidateSingleConfig_Username(t *testing.T) {
+	for _, username := range []string{"user@@example.com", "user", "User <user@example.com>", " user@example.com"} {
+		err := validateSingleConfig(&ConfigMapper{
+			Host:     "smtp.example.com",
+			Port:     465,
+			Username: username,
+			Password: "password",
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid username") {
+			t.Errorf("expected invalid username error for %q, got %v", username, err)
+		}
+	}
+	if err := validateSingleConfig(&ConfigMapper{
+		Host:     "smtp.example.com",
+		Port:     465,
+		Username: "user@example.com",
+		Password: "password",
+	}); err != nil {
+		t.Errorf("unexpected error for valid username: %v", err)
+	}
+}
+
+// TestEmail_VERP tests encoding the recipient into the envelope sender
+func TestEmail_VERP(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.BounceAddress = "bounce@mydomain.com"
+	email.VERP = true
+
+	toList := []mail.Address{{Address: "recipient@example.com"}, {Address: "other@example.org"}}
+	for _, result := range email.SendGroup("", toList, "subject", "content") {
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+	}
+
+	var senders []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			senders = append(senders, cmd)
+		}
+	}
+	want := []string{
+		"MAIL FROM:<bounce+recipient=example.com@mydomain.com>",
+		"MAIL FROM:<bounce+other=example.org@mydomain.com>",
+	}
+	if strings.Join(senders, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected per-recipient envelope senders %q, got %q", want, senders)
+	}
+	if got := len(server.received()); got != 2 {
+		t.Errorf("expected one transaction per recipient, got %d", got)
+	}
+}
+
+// TestEmail_RequireTLS tests sending MAIL FROM with REQUIRETLS over an upgraded connection
+func TestEmail_RequireTLS(t *testing.T) {
+	server := (&mockServer{extensions: []string{"STARTTLS", "REQUIRETLS", "AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.RequireTLS = true
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if server.tlsUpgrades() != 1 {
+		t.Errorf("expected connection to be upgraded with STARTTLS")
+	}
+	found := false
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			found = strings.HasSuffix(cmd, " REQUIRETLS")
+		}
+	}
+	if !found {
+		t.Errorf("expected MAIL FROM with REQUIRETLS parameter, got %q", server.commands())
+	}
+}
+
+// TestEmail_RequireTLSUnsupported tests that nothing is sent when the server does not support REQUIRETLS
+func TestEmail_RequireTLSUnsupported(t *testing.T) {
+	server := (&mockServer{extensions: []string{"STARTTLS", "AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.RequireTLS = true
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "REQUIRETLS") {
+		t.Fatalf("expected REQUIRETLS error, got %v", errs)
+	}
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "MAIL") {
+			t.Errorf("expected no transaction without REQUIRETLS support, got %q", cmd)
+		}
+	}
+}
+
+// TestEmail_ArchiveBCC tests adding the archive address as an extra envelope recipient
+func TestEmail_ArchiveBCC(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.ArchiveBCC = "archive@example.com"
+
+	results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	var rcpts []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if strings.Join(rcpts, ",") != "RCPT TO:<a@example.com>,RCPT TO:<archive@example.com>" {
+		t.Errorf("expected archive copy via RCPT, got %q", rcpts)
+	}
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("expected one message, got %d", len(received))
+	}
+	header, _ := splitMessage(t, []byte(received[0]))
+	if strings.Contains(header, "archive@example.com") {
+		t.Errorf("archive address must not appear in headers:\n%s", header)
+	}
+}
+
+// TestEmail_ArchiveBCCWithExplicitBcc tests that an archive address already in Bcc is not added twice
+func TestEmail_ArchiveBCCWithExplicitBcc(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.ArchiveBCC = "archive@example.com"
+
+	results := email.BatchSend(context.Background(), []*Message{{
+		To:  []mail.Address{{Address: "a@example.com"}},
+		Bcc: []mail.Address{{Address: "b@example.com"}, {Address: "ARCHIVE@example.com"}},
+	}})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	var rcpts []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if len(rcpts) != 3 {
+		t.Errorf("expected archive address to be deduplicated against Bcc, got %q", rcpts)
+	}
+	header, _ := splitMessage(t, []byte(server.received()[0]))
+	if strings.Contains(header, "b@example.com") || strings.Contains(strings.ToLower(header), "bcc") {
+		t.Errorf("Bcc recipients must not appear in headers:\n%s", header)
+	}
+}
+
+// TestEmail_AuthNotAdvertised tests the error when the server does not advertise AUTH
+func TestEmail_AuthNotAdvertised(t *testing.T) {
+	server := (&mockServer{extensions: []string{"PIPELINING"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "does not advertise AUTH") {
+		t.Fatalf("expected descriptive AUTH error, got %v", errs)
+	}
+	for _, verb := range verbs(server.commands()) {
+		if verb == "AUTH" || verb == "MAIL" {
+			t.Errorf("expected no %s command when AUTH is not advertised", verb)
+		}
+	}
+}
+
+// TestEmail_AuthMechanismNotAdvertised tests the error when AUTH lacks the configured mechanism, with and without TLS
+func TestEmail_AuthMechanismNotAdvertised(t *testing.T) {
+	for _, tc := range []struct {
+		useTLS bool
+		check  func(error) bool
+	}{
+		{false, func(err error) bool { return errors.Is(err, ErrUnencrypted) }},
+		{true, func(err error) bool { return strings.Contains(err.Error(), "does not support AUTH LOGIN") }},
+	} {
+		server := (&mockServer{extensions: []string{"AUTH CRAM-MD5"}, implicitTLS: tc.useTLS}).start(t)
+		config := server.config(tc.useTLS)
+		config.AuthType = AuthLogin
+		email := New(map[string]*ConfigMapper{"default": config})
+
+		errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content")
+		if len(errs) != 1 || !tc.check(errs[0]) {
+			t.Errorf("TLS %v: unexpected errors: %v", tc.useTLS, errs)
+		}
+		for _, verb := range verbs(server.commands()) {
+			if verb == "AUTH" {
+				t.Errorf("TLS %v: expected no AUTH command", tc.useTLS)
+			}
+		}
+	}
+}
+
+// TestEmail_AuthSkippedWithoutCredentials tests that AUTH is not sent with AuthNone
+func TestEmail_AuthSkippedWithoutCredentials(t *testing.T) {
+	server := (&mockServer{}).start(t)
+	config := server.config(false)
+	config.AuthType = AuthNone
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for _, verb := range verbs(server.commands()) {
+		if verb == "AUTH" {
+			t.Error("expected AUTH to be skipped without credentials")
+		}
+	}
+}
+
+// TestEmail_EnvelopeRecipient tests that HeaderTo and Envelope change the To header without changing RCPT TO
+func TestEmail_EnvelopeRecipient(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	list := []mail.Address{{Name: "Announce", Address: "list@example.com"}}
+	results := email.SendWithOptions("", []mail.Address{{Address: "subscriber@example.org"}}, "subject", "content", SendOptions{HeaderTo: list})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	batch := email.BatchSend(context.Background(), []*Message{{
+		To:       list,
+		Envelope: []mail.Address{{Address: "other@example.org"}},
+	}})
+	if batch[0].Err != nil {
+		t.Fatalf("unexpected error: %v", batch[0].Err)
+	}
+
+	var rcpts []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if strings.Join(rcpts, ",") != "RCPT TO:<subscriber@example.org>,RCPT TO:<other@example.org>" {
+		t.Errorf("expected envelope recipients in RCPT TO, got %q", rcpts)
+	}
+	for _, message := range server.received() {
+		header, _ := splitMessage(t, []byte(message))
+		if !strings.Contains(header, `To: "Announce" <list@example.com>`) {
+			t.Errorf("expected To header to show the list address:\n%s", header)
+		}
+		if strings.Contains(header, "example.org") {
+			t.Errorf("envelope recipient must not appear in headers:\n%s", header)
+		}
+	}
+}
+
+// TestEmail_RewriteRecipient tests routing and delivering to the rewritten recipient
+func TestEmail_RewriteRecipient(t *testing.T) {
+	production := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	staging := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{
+		"default":      production.config(false),
+		"staging.test": staging.config(false),
+	})
+	email.RewriteRecipient = func(mail.Address) mail.Address {
+		return mail.Address{Address: "inbox@staging.test"}
+	}
+
+	if errs := email.Send("", []mail.Address{{Name: "User", Address: "user@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got := production.connections(); got != 0 {
+		t.Errorf("expected no delivery to the production relay, got %d connections", got)
+	}
+	var rcpts []string
+	for _, cmd := range staging.commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if strings.Join(rcpts, ",") != "RCPT TO:<inbox@staging.test>" {
+		t.Errorf("expected redirected envelope recipient, got %q", rcpts)
+	}
+	received := staging.received()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(received))
+	}
+	header, _ := splitMessage(t, []byte(received[0]))
+	if !strings.Contains(header, "X-Original-To: user@example.com\r\n") {
+		t.Errorf("expected original recipient in X-Original-To:\n%s", header)
+	}
+	if !strings.Contains(header, "To: <inbox@staging.test>\r\n") {
+		t.Errorf("expected To header to show the rewritten recipient:\n%s", header)
+	}
+}
+
+// TestEmail_SendResultSize tests that SendResult.Size is the size of the delivered message
+func TestEmail_SendResultSize(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	to := mail.Address{Address: "a@example.com"}
+	results := email.SendWithOptions("", []mail.Address{to}, "subject", "content", SendOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	// Date头为固定宽度，同样内容的邮件长度一致
+	message, err := email.buildMessage(fromAddress(server.config(false), ""), []mail.Address{to}, "subject", "content", SendOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Size != len(message) {
+		t.Errorf("expected size %d, got %d", len(message), results[0].Size)
+	}
+}
+
+// TestEmail_HELOAddressLiteral tests using the local address literal when the hostname is not a domain
+func TestEmail_HELOAddressLiteral(t *testing.T) {
+	original := hostname
+	hostname = func() (string, error) { return "localhost", nil }
+	defer func() { hostname = original }()
+
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.HELOAddressLiteral = true
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got := server.commands()[0]; got != "EHLO [127.0.0.1]" {
+		t.Errorf("expected bracketed address literal, got %q", got)
+	}
+}
+
+// TestAddressLiteral tests the IPv4 and IPv6 address literal formats
+func TestAddressLiteral(t *testing.T) {
+	for ip, want := range map[string]string{
+		"192.0.2.1":   "[192.0.2.1]",
+		"2001:db8::1": "[IPv6:2001:db8::1]",
+	} {
+		if got := addressLiteral(net.ParseIP(ip)); got != want {
+			t.Errorf("addressLiteral(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+// TestEmail_HeadersFor tests per-recipient headers and rejecting header injection
+func TestEmail_HeadersFor(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.ReuseConnection = true
+
+	toList := []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}, {Address: "evil@example.com"}}
+	results := email.SendWithOptions("", toList, "subject", "content", SendOptions{
+		HeadersFor: func(to mail.Address) map[string]string {
+			if to.Address == "evil@example.com" {
+				return map[string]string{"List-Unsubscribe": "<x>\r\nBcc: victim@example.com"}
+			}
+			return map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe?u=" + to.Address + ">"}
+		},
+	})
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("unexpected errors: %v, %v", results[0].Err, results[1].Err)
+	}
+	if results[2].Err == nil {
+		t.Error("expected header injection to be rejected")
+	}
+
+	received := server.received()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(received))
+	}
+	for i, message := range received {
+		header, _ := splitMessage(t, []byte(message))
+		want := "List-Unsubscribe: <https://example.com/unsubscribe?u=" + toList[i].Address + ">\r\n"
+		if !strings.Contains(header, want) {
+			t.Errorf("message %d: expected %q in headers:\n%s", i, want, header)
+		}
+	}
+}
+
+// TestEmail_SubjectInjection tests that a subject with a line break is rejected by Send and a non-ASCII subject is encoded
+func TestEmail_SubjectInjection(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	errs := email.Send("", []mail.Address{{Address: "user@example.com"}}, "a\r\nBcc: x@y", "content")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "invalid value for header Subject") {
+		t.Fatalf("expected the subject to be rejected, got %v", errs)
+	}
+	if received := server.received(); len(received) != 0 {
+		t.Fatalf("expected nothing to be sent, got:\n%s", received[0])
+	}
+
+	if errs := email.Send("", []mail.Address{{Address: "user@example.com"}}, "月报", "content"); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if header, _ := splitMessage(t, []byte(server.received()[0])); !strings.Contains(header, "Subject: =?UTF-8?q?=E6=9C=88=E6=8A=A5?=\r\n") {
+		t.Errorf("expected RFC 2047 encoded subject:\n%s", header)
+	}
+}
+
+// TestEmail_AuthRequiresEncryption tests ErrUnencrypted when AUTH is only offered after STARTTLS
+func TestEmail_AuthRequiresEncryption(t *testing.T) {
+	server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	want := "email: server requires encrypted connection for LOGIN auth; set TLS or OpportunisticTLS"
+	if results[0].Err == nil || results[0].Err.Error() != want {
+		t.Errorf("expected %q, got %v", want, results[0].Err)
+	}
+	if !errors.Is(results[0].Err, ErrUnencrypted) {
+		t.Errorf("expected ErrUnencrypted, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_AuthStartTLS tests upgrading with STARTTLS when the required AUTH mechanism is only offered after encryption
+func TestEmail_AuthStartTLS(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		tlsExts   []string
+		mechanism string
+	}{
+		{"login", []string{"AUTH LOGIN PLAIN"}, "AUTH LOGIN"},
+		{"plain only", []string{"AUTH PLAIN"}, "AUTH PLAIN"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: tc.tlsExts}).start(t)
+			config := server.config(false)
+			config.AuthStartTLS = true
+			email := New(map[string]*ConfigMapper{"default": config})
+			email.SuppressInsecureWarning = true
+
+			results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+			if results[0].Err != nil {
+				t.Fatalf("unexpected error: %v", results[0].Err)
+			}
+			if server.tlsUpgrades() != 1 {
+				t.Errorf("expected one STARTTLS upgrade, got %d", server.tlsUpgrades())
+			}
+			if !slices.ContainsFunc(server.commands(), func(cmd string) bool { return strings.HasPrefix(cmd, tc.mechanism) }) {
+				t.Errorf("expected %s in commands %v", tc.mechanism, server.commands())
+			}
+		})
+	}
+
+	// 明文连接已声明LOGIN时不升级
+	server := (&mockServer{extensions: []string{"STARTTLS", "AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.AuthStartTLS = true
+	email := New(map[string]*ConfigMapper{"default": config})
+	if results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	if server.tlsUpgrades() != 0 {
+		t.Errorf("expected no STARTTLS upgrade, got %d", server.tlsUpgrades())
+	}
+}
+
+// TestNotAuth_UnencryptedConnection tests that NotAuth refuses LOGIN on a plaintext connection without LOGIN advertised
+func TestNotAuth_UnencryptedConnection(t *testing.T) {
+	auth := &NotAuth{Host: "127.0.0.1", Username: "sender@example.com", Password: "secret"}
+	_, _, err := auth.Start(&smtp.ServerInfo{Name: "127.0.0.1", Auth: []string{"PLAIN"}})
+	if err == nil || !strings.HasPrefix(err.Error(), "email: server requires encrypted connection") {
+		t.Errorf("expected encrypted connection error, got %v", err)
+	}
+}
+
+// TestEmail_SendVia tests sending every recipient through an explicit configuration
+func TestEmail_SendVia(t *testing.T) {
+	routed := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	relay := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{
+		"default":     routed.config(false),
+		"example.com": routed.config(false),
+	})
+	results := email.SendVia(relay.config(false), "测试发件人", []mail.Address{
+		{Address: "a@example.com"},
+		{Address: "b@other.com"},
+	}, "测试主题", "测试内容", SendOptions{})
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("unexpected error for %s: %v", result.Recipient.Address, result.Err)
+		}
+	}
+	if received := relay.received(); len(received) != 2 {
+		t.Errorf("expected 2 messages via relay, got %d", len(received))
+	}
+	if routed.connections() != 0 {
+		t.Errorf("expected no connections to the routed server, got %d", routed.connections())
+	}
+
+	invalid := relay.config(false)
+	invalid.Host = ""
+	results = email.SendVia(invalid, "", []mail.Address{{Address: "a@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "invalid configuration") {
+		t.Errorf("expected invalid configuration error, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_AuthorizedDomains tests checking the From domain against the relay's authorized domains
+func TestEmail_AuthorizedDomains(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.FromAddress = "noreply@unauthorized.com"
+	config.AuthorizedDomains = []string{"example.com"}
+
+	var logs []string
+	email := New(map[string]*ConfigMapper{"default": config})
+	email.SuppressInsecureWarning = true
+	email.Logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	toList := []mail.Address{{Address: "user@example.com"}}
+	results := email.SendWithOptions("", toList, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("expected warning only, got %v", results[0].Err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], `from domain "unauthorized.com" is not authorized`) {
+		t.Errorf("expected misaligned from warning, got %q", logs)
+	}
+	if received := server.received(); len(received) != 1 || !strings.Contains(received[0], "From: <noreply@unauthorized.com>") {
+		t.Errorf("expected message from FromAddress, got %q", received)
+	}
+
+	email.RejectMisalignedFrom = true
+	results = email.SendWithOptions("", toList, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "is not authorized for relay") {
+		t.Errorf("expected misaligned from error, got %v", results[0].Err)
+	}
+	if received := server.received(); len(received) != 1 {
+		t.Errorf("expected no further messages, got %d", len(received))
+	}
+}
+
+// TestEmail_Force7Bit tests that the transmitted message contains only 7-bit bytes
+func TestEmail_Force7Bit(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Force7Bit = true
+
+	attachment := NewAttachment("报告.txt", []byte("内容"))
+	results := email.SendWithOptions("测试发件人", []mail.Address{{Name: "收件人", Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{
+		TransferEncoding: Encoding8Bit,
+		Attachments:      []*Attachment{attachment},
+		HeadersFor: func(mail.Address) map[string]string {
+			return map[string]string{"X-Campaign": "双十一"}
+		},
+	})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(received))
+	}
+	for i := 0; i < len(received[0]); i++ {
+		if received[0][i] > 0x7f {
+			t.Fatalf("byte 0x%02x at offset %d is not 7-bit", received[0][i], i)
+		}
+	}
+	if !strings.Contains(received[0], "Subject: =?UTF-8?q?") {
+		t.Errorf("expected RFC 2047 encoded subject, got:\n%s", received[0])
+	}
+
+	results = email.SendWithOptions("", []mail.Address{{Address: "用户@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "not 7bit clean") {
+		t.Errorf("expected 7bit error for non-ASCII address, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_InvalidDefaultConfig tests failing fast without connecting when the resolved config is invalid
+func TestEmail_InvalidDefaultConfig(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.Password = ""
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	results := email.SendWithOptions("", []mail.Address{
+		{Address: "a@example.com"},
+		{Address: "b@example.com"},
+	}, "测试主题", "测试内容", SendOptions{})
+	for _, result := range results {
+		if result.Err == nil || !strings.Contains(result.Err.Error(), "invalid configuration for "+result.Recipient.Address+": empty password") {
+			t.Errorf("expected configuration error for %s, got %v", result.Recipient.Address, result.Err)
+		}
+		if !errors.Is(result.Err, ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig for %s, got %v", result.Recipient.Address, result.Err)
+		}
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connections, got %d", server.connections())
+	}
+}
+
+// TestEmail_GreetingTimeout tests giving up on a server that delays its banner
+func TestEmail_GreetingTimeout(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, greetingDelay: 2 * time.Second}).start(t)
+	config := server.config(false)
+	config.GreetingTimeout = 100 * time.Millisecond
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	start := time.Now()
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected send to fail within GreetingTimeout, took %v", elapsed)
+	}
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "failed to read greeting") || !isTimeout(results[0].Err) {
+		t.Errorf("expected greeting timeout, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_ResponseLineTooLong tests bounding the length of server response lines
+func TestEmail_ResponseLineTooLong(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if strings.HasPrefix(cmd, "MAIL FROM:") {
+				return "250 " + strings.Repeat("x", 64<<10)
+			}
+			return ""
+		},
+	}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if !errors.Is(results[0].Err, ErrResponseTooLong) {
+		t.Errorf("expected ErrResponseTooLong with the default limit, got %v", results[0].Err)
+	}
+
+	config := server.config(false)
+	config.MaxResponseLineLength = 100 << 10
+	email = New(map[string]*ConfigMapper{"default": config})
+	results = email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err != nil {
+		t.Errorf("expected response within the configured limit to be accepted, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_HELOStrategy tests the HELO name chosen by each strategy
+func TestEmail_HELOStrategy(t *testing.T) {
+	originalHostname, originalLookupAddr := hostname, lookupAddr
+	hostname = func() (string, error) { return "host.internal.example.com", nil }
+	lookupAddr = func(addr string) ([]string, error) {
+		if addr != "127.0.0.1" {
+			return nil, fmt.Errorf("unexpected lookup for %s", addr)
+		}
+		return []string{"mail.example.com."}, nil
+	}
+	defer func() { hostname, lookupAddr = originalHostname, originalLookupAddr }()
+
+	for _, tt := range []struct {
+		strategy string
+		name     string
+		want     string
+	}{
+		{HELODefault, "", "EHLO localhost"},
+		{HELOStatic, "static.example.com", "EHLO static.example.com"},
+		{HELOHostname, "", "EHLO host.internal.example.com"},
+		{HELOReverseDNS, "", "EHLO mail.example.com"},
+		{HELOLocalhost, "", "EHLO localhost"},
+	} {
+		server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+		config := server.config(false)
+		config.HELOStrategy = tt.strategy
+		config.HELOName = tt.name
+		email := New(map[string]*ConfigMapper{"default": config})
+
+		if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+			t.Fatalf("strategy %q: unexpected errors: %v", tt.strategy, errs)
+		}
+		if got := server.commands()[0]; got != tt.want {
+			t.Errorf("strategy %q: expected %q, got %q", tt.strategy, tt.want, got)
+		}
+	}
+
+	if err := validateSingleConfig(&ConfigMapper{Host: "smtp.example.com", Port: 25, Username: "a@example.com", Password: "secret", HELOStrategy: HELOStatic}); err == nil {
+		t.Error("expected static strategy without HELOName to be rejected")
+	}
+}
+
+// TestEmail_Envelope tests previewing the envelope addresses without connecting
+func TestEmail_Envelope(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.FromAddress = "support@example.com"
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	email.BounceAddress = "bounce@mydomain.com"
+	email.VERP = true
+	email.RewriteRecipient = func(addr mail.Address) mail.Address {
+		return mail.Address{Address: strings.Replace(addr.Address, "@old.example.com", "@example.com", 1)}
+	}
+
+	mailFrom, rcptTo, err := email.Envelope("客服", mail.Address{Address: "user@old.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mailFrom != "bounce+user=example.com@mydomain.com" || rcptTo != "user@example.com" {
+		t.Errorf("unexpected envelope: MAIL FROM %q, RCPT TO %q", mailFrom, rcptTo)
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connections, got %d", server.connections())
+	}
+
+	// 未设置BounceAddress时信封发件人为Username，而不是From头的FromAddress
+	email.BounceAddress, email.VERP = "", false
+	if mailFrom, _, _ = email.Envelope("", mail.Address{Address: "user@example.com"}); mailFrom != "sender@example.com" {
+		t.Errorf("expected Username as envelope sender, got %q", mailFrom)
+	}
+
+	email.StrictRouting = true
+	if _, _, err = email.Envelope("", mail.Address{Address: "user@unknown.com"}); !errors.Is(err, ErrNoConfig) {
+		t.Errorf("expected ErrNoConfig for unroutable recipient, got %v", err)
+	}
+}
+
+// TestEmail_MaxRejectedRatio tests skipping DATA when most recipients are rejected
+func TestEmail_MaxRejectedRatio(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		extensions := []string{"AUTH LOGIN PLAIN"}
+		if pipelining {
+			extensions = append(extensions, "PIPELINING")
+		}
+		server := (&mockServer{
+			extensions: extensions,
+			reply: func(cmd string) string {
+				if strings.HasPrefix(cmd, "RCPT TO:") && !strings.HasPrefix(cmd, "RCPT TO:<ok@") {
+					return "550 5.1.1 user unknown"
+				}
+				return ""
+			},
+		}).start(t)
+		email := New(map[string]*ConfigMapper{"default": server.config(false)})
+		email.MaxRejectedRatio = 0.5
+
+		toList := []mail.Address{{Address: "ok@example.com"}}
+		for i := 0; i < 9; i++ {
+			toList = append(toList, mail.Address{Address: fmt.Sprintf("bad%d@example.com", i)})
+		}
+		results := email.SendGroup("", toList, "subject", "content")
+		if !errors.Is(results[0].Err, ErrTooManyRejections) || !strings.Contains(results[0].Err.Error(), "9 of 10 recipients rejected") {
+			t.Errorf("pipelining=%v: expected rejection rate error for accepted recipient, got %v", pipelining, results[0].Err)
+		}
+		for _, verb := range verbs(server.commands()) {
+			if verb == "DATA" {
+				t.Errorf("pipelining=%v: expected DATA to be skipped", pipelining)
+			}
+		}
+		if len(server.received()) != 0 {
+			t.Errorf("pipelining=%v: expected no message to be delivered", pipelining)
+		}
+	}
+}
+
+// TestEmail_CheckFromAlignment tests warning about a From domain that differs from the username domain
+func TestEmail_CheckFromAlignment(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.FromAddress = "news@brand.com"
+
+	var logs []string
+	email := New(map[string]*ConfigMapper{"default": config})
+	email.SuppressInsecureWarning = true
+	email.CheckFromAlignment = true
+	email.Logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("expected alignment check not to block sending, got %v", results[0].Err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], `from domain "brand.com" differs from username domain "example.com"`) {
+		t.Errorf("expected DMARC alignment warning, got %q", logs)
+	}
+
+	logs = nil
+	config.FromAddress = ""
+	email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if len(logs) != 0 {
+		t.Errorf("expected no warning for an aligned From, got %q", logs)
+	}
+}
+
+// TestEmail_CommandTimeout tests timing out a multi-line response that arrives too slowly
+func TestEmail_CommandTimeout(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME", "SIZE 1000000"}, lineDelay: 150 * time.Millisecond}).start(t)
+	config := server.config(false)
+	config.CommandTimeout = 100 * time.Millisecond
+
+	email := New(map[string]*ConfigMapper{"default": config})
+	start := time.Now()
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if !isTimeout(results[0].Err) {
+		t.Fatalf("expected timeout on slow EHLO response, got %v", results[0].Err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected send to fail within CommandTimeout, took %v", elapsed)
+	}
+
+	// 每个响应都在CommandTimeout内完成时，整个会话可以超过CommandTimeout
+	for _, implicitTLS := range []bool{false, true} {
+		server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME"}, implicitTLS: implicitTLS, lineDelay: 50 * time.Millisecond}).start(t)
+		config := server.config(implicitTLS)
+		config.CommandTimeout = time.Second
+		email := New(map[string]*ConfigMapper{"default": config})
+		results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+		if results[0].Err != nil {
+			t.Errorf("implicitTLS=%v: unexpected error: %v", implicitTLS, results[0].Err)
+		}
+	}
+}
+
+// TestEmail_RoutePlan tests grouping recipients by relay without sending
+func TestEmail_RoutePlan(t *testing.T) {
+	email := New(map[string]*ConfigMapper{
+		"example.com": {Host: "smtp.example.com", Port: 587, Username: "a@example.com", Password: "secret"},
+		"example.org": {Host: "smtp.example.org", Port: 25, Username: "a@example.org", Password: "secret"},
+		"example.net": {Host: "smtp.example.net", Port: 25, Username: "a@example.net"},
+	})
+	plan := email.RoutePlan([]mail.Address{
+		{Address: "a@example.com"},
+		{Address: "b@example.org"},
+		{Address: "c@example.com"},
+		{Address: "d@unknown.com"},
+		{Address: "e@example.net"},
+	})
+
+	want := map[string][]string{
+		"smtp.example.com:587": {"a@example.com", "c@example.com"},
+		"smtp.example.org:25":  {"b@example.org"},
+		Unroutable:             {"d@unknown.com", "e@example.net"},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("expected %d groups, got %v", len(want), plan)
+	}
+	for key, addresses := range want {
+		var got []string
+		for _, addr := range plan[key] {
+			got = append(got, addr.Address)
+		}
+		if strings.Join(got, ",") != strings.Join(addresses, ",") {
+			t.Errorf("%s: expected %v, got %v", key, addresses, got)
+		}
+	}
+}
+
+// TestEmail_Quit tests the three ways of ending a session
+func TestEmail_Quit(t *testing.T) {
+	to := []mail.Address{{Address: "user@example.com"}}
+
+	// 默认发送QUIT并检查响应
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	if results := email.SendWithOptions("", to, "测试主题", "测试内容", SendOptions{}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	if cmds := verbs(server.commands()); cmds[len(cmds)-1] != "QUIT" {
+		t.Errorf("expected session to end with QUIT, got %q", cmds)
+	}
+
+	// QuitSkip不发送QUIT，直接关闭连接
+	server = (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email = New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Quit = QuitSkip
+	if results := email.SendWithOptions("", to, "测试主题", "测试内容", SendOptions{}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	if cmds := verbs(server.commands()); strings.Contains(strings.Join(cmds, " "), "QUIT") {
+		t.Errorf("expected no QUIT, got %q", cmds)
+	}
+
+	// QUIT失败时默认记录到结果，QuitIgnoreError忽略
+	server = (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if cmd == "QUIT" {
+				return "421 closing"
+			}
+			return ""
+		},
+	}).start(t)
+	email = New(map[string]*ConfigMapper{"default": server.config(false)})
+	results := email.SendWithOptions("", to, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "failed to quit") {
+		t.Errorf("expected QUIT error, got %v", results[0].Err)
+	}
+	if !errors.Is(results[0].Err, ErrQuitFailed) || results[0].Retryable() || len(RetryableRecipients(results)) != 0 {
+		t.Errorf("expected a delivered message with a failed QUIT not to be retryable, got %v", results[0].Err)
+	}
+	if len(server.received()) != 1 {
+		t.Errorf("expected message to be delivered once, got %d", len(server.received()))
+	}
+	email.Quit = QuitIgnoreError
+	if results := email.SendWithOptions("", to, "测试主题", "测试内容", SendOptions{}); results[0].Err != nil {
+		t.Errorf("unexpected error: %v", results[0].Err)
+	}
+}
+
+// TestEmail_EnvelopeSenders tests choosing the envelope sender aligned with the From domain
+func TestEmail_EnvelopeSenders(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	config.EnvelopeSenders = []string{"bounce@brand-a.com", "bounce@brand-b.com"}
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	results := email.BatchSend(context.Background(), []*Message{
+		{From: mail.Address{Address: "news@brand-b.com"}, To: []mail.Address{{Address: "user@example.org"}}},
+		{From: mail.Address{Address: "news@BRAND-A.com"}, To: []mail.Address{{Address: "user@example.org"}}},
+		{From: mail.Address{Address: "news@other.com"}, To: []mail.Address{{Address: "user@example.org"}}},
+	})
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("message %d: unexpected error: %v", i, result.Err)
+		}
+	}
+
+	var senders []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			senders = append(senders, strings.Fields(cmd)[1])
+		}
+	}
+	want := "FROM:<bounce@brand-b.com>,FROM:<bounce@brand-a.com>,FROM:<sender@example.com>"
+	if strings.Join(senders, ",") != want {
+		t.Errorf("expected envelope senders %s, got %q", want, senders)
+	}
+
+	config.FromAddress = "support@brand-a.com"
+	if mailFrom, _, _ := email.Envelope("", mail.Address{Address: "user@example.org"}); mailFrom != "bounce@brand-a.com" {
+		t.Errorf("expected aligned envelope sender, got %q", mailFrom)
+	}
+}
+
+// TestEmail_OnConnect tests that dial, TLS handshake and AUTH durations are reported separately
+func TestEmail_OnConnect(t *testing.T) {
+	const tlsDelay, authDelay = 100 * time.Millisecond, 250 * time.Millisecond
+	for _, implicitTLS := range []bool{false, true} {
+		server := (&mockServer{
+			extensions:  []string{"STARTTLS", "AUTH LOGIN PLAIN"},
+			implicitTLS: implicitTLS,
+			tlsDelay:    tlsDelay,
+			authDelay:   authDelay,
+		}).start(t)
+		config := server.config(implicitTLS)
+		config.OpportunisticTLS = true
+
+		var reported []ConnectMetrics
+		email := New(map[string]*ConfigMapper{"default": config})
+		email.OnConnect = func(metrics ConnectMetrics) { reported = append(reported, metrics) }
+		results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("implicitTLS=%v: unexpected error: %v", implicitTLS, results[0].Err)
+		}
+		if len(reported) != 1 {
+			t.Fatalf("implicitTLS=%v: expected one report, got %d", implicitTLS, len(reported))
+		}
+		metrics := reported[0]
+		if metrics.Err != nil || metrics.Addr != net.JoinHostPort(config.Host, strconv.Itoa(config.Port)) {
+			t.Errorf("implicitTLS=%v: unexpected report %+v", implicitTLS, metrics)
+		}
+		if metrics.Dial >= tlsDelay {
+			t.Errorf("implicitTLS=%v: expected fast dial, got %v", implicitTLS, metrics.Dial)
+		}
+		if metrics.TLSHandshake < tlsDelay || metrics.TLSHandshake >= authDelay {
+			t.Errorf("implicitTLS=%v: expected TLS handshake around %v, got %v", implicitTLS, tlsDelay, metrics.TLSHandshake)
+		}
+		if metrics.Auth < authDelay {
+			t.Errorf("implicitTLS=%v: expected auth of at least %v, got %v", implicitTLS, authDelay, metrics.Auth)
+		}
+		if metrics.Total < metrics.Dial+metrics.TLSHandshake+metrics.Auth {
+			t.Errorf("implicitTLS=%v: total %v less than the sum of phases", implicitTLS, metrics.Total)
+		}
+	}
+}
+
+// TestEmail_DeniedDomains tests rejecting recipients by domain before connecting
+func TestEmail_DeniedDomains(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.DeniedDomains = []string{"gmail.com"}
+
+	results := email.SendWithOptions("", []mail.Address{{Address: "someone@GMAIL.com"}}, "测试主题", "测试内容", SendOptions{})
+	if !errors.Is(results[0].Err, ErrRecipientNotAllowed) {
+		t.Errorf("expected ErrRecipientNotAllowed, got %v", results[0].Err)
+	}
+	batch := email.BatchSend(context.Background(), []*Message{{
+		To:  []mail.Address{{Address: "team@corp.example.com"}},
+		Bcc: []mail.Address{{Address: "someone@gmail.com"}},
+	}})
+	if !errors.Is(batch[0].Err, ErrRecipientNotAllowed) {
+		t.Errorf("expected ErrRecipientNotAllowed for denied Bcc, got %v", batch[0].Err)
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connection attempt, got %d", server.connections())
+	}
+
+	// AllowedDomains只允许列出的域名，其余收件人正常发送
+	email.DeniedDomains = nil
+	email.AllowedDomains = []string{"corp.example.com"}
+	results = email.SendWithOptions("", []mail.Address{{Address: "team@corp.example.com"}, {Address: "user@example.org"}},
+		"测试主题", "测试内容", SendOptions{})
+	if results[0].Err != nil {
+		t.Errorf("unexpected error for allowed domain: %v", results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrRecipientNotAllowed) {
+		t.Errorf("expected ErrRecipientNotAllowed, got %v", results[1].Err)
+	}
+	if server.connections() != 1 {
+		t.Errorf("expected one connection for the allowed recipient, got %d", server.connections())
+	}
+}
+
+// TestEmail_BeforeSend tests that a recipient suppressed by the hook is skipped with its error
+func TestEmail_BeforeSend(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	errSuppressed := errors.New("recipient unsubscribed")
+	var mu sync.Mutex
+	var seen []string
+	email.BeforeSend = func(ctx context.Context, recipient mail.Address, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, recipient.Address)
+		if recipient.Address == "unsubscribed@example.com" {
+			return errSuppressed
+		}
+		return nil
+	}
+
+	results := email.SendWithOptions("", []mail.Address{
+		{Address: "first@example.com"},
+		{Address: "unsubscribed@example.com"},
+		{Address: "second@example.com"},
+	}, "测试主题", "测试内容", SendOptions{})
+	if !errors.Is(results[1].Err, errSuppressed) {
+		t.Errorf("expected the hook's error for the suppressed recipient, got %v", results[1].Err)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("unexpected error for %s: %v", results[i].Recipient.Address, results[i].Err)
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected the hook to run for every recipient, got %v", seen)
+	}
+	if got := len(server.received()); got != 2 {
+		t.Errorf("expected 2 messages sent, got %d", got)
+	}
+	for _, cmd := range server.commands() {
+		if strings.Contains(cmd, "unsubscribed@example.com") {
+			t.Errorf("expected the suppressed recipient to be skipped, got %q", cmd)
+		}
+	}
+
+	// BatchSend中被跳过的收件人不加入信封，其他收件人照常投递
+	batch := email.BatchSend(context.Background(), []*Message{{
+		To: []mail.Address{{Address: "first@example.com"}, {Address: "unsubscribed@example.com"}},
+	}})
+	if !errors.Is(batch[0].Err, errSuppressed) {
+		t.Errorf("expected the hook's error in the batch result, got %v", batch[0].Err)
+	}
+	if got := len(server.received()); got != 3 {
+		t.Errorf("expected the batch message to be sent to the remaining recipient, got %d messages", got)
+	}
+}
+
+// TestEmail_MaxRecipientsPerSend tests rejecting a whole send whose recipient list is over the limit
+func TestEmail_MaxRecipientsPerSend(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.MaxRecipientsPerSend = 2
+	toList := []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}, {Address: "c@example.com"}}
+
+	results := email.SendWithOptions("", toList, "测试主题", "测试内容", SendOptions{})
+	if len(results) != len(toList) {
+		t.Fatalf("expected one result per recipient, got %d", len(results))
+	}
+	for i, result := range results {
+		if !errors.Is(result.Err, ErrTooManyRecipients) || result.Recipient != toList[i] {
+			t.Errorf("expected ErrTooManyRecipients for %s, got %+v", toList[i].Address, result)
+		}
+	}
+	if results := email.SendGroup("", toList, "测试主题", "测试内容"); !errors.Is(results[0].Err, ErrTooManyRecipients) {
+		t.Errorf("expected SendGroup to be rejected, got %v", results[0].Err)
+	}
+	batch := email.BatchSend(context.Background(), []*Message{{To: toList[:1]}, {To: toList[1:]}})
+	if !errors.Is(batch[0].Err, ErrTooManyRecipients) || !errors.Is(batch[1].Err, ErrTooManyRecipients) {
+		t.Errorf("expected the batch to be rejected by its total recipient count, got %v", batch)
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected nothing sent, got %d connections", server.connections())
+	}
+
+	if results := email.SendWithOptions("", toList[:2], "测试主题", "测试内容", SendOptions{}); results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("expected a send within the limit to succeed, got %v", results)
+	}
+}
+
+// TestEmail_InsecureWarning tests logging the SkipTLSVerify warning once per server
+func TestEmail_InsecureWarning(t *testing.T) {
+	first := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	second := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	verified := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	verifiedConfig := verified.config(false)
+	verifiedConfig.SkipTLSVerify = false
+	email := New(map[string]*ConfigMapper{
+		"default":     first.config(false),
+		"example.org": second.config(false),
+		"example.net": verifiedConfig,
+	})
+	var mu sync.Mutex
+	var logs []string
+	email.Logf = func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	toList := []mail.Address{{Address: "a@example.com"}, {Address: "b@example.org"}, {Address: "c@example.net"}}
+	for range 3 {
+		for _, result := range email.SendWithOptions("", toList, "测试主题", "测试内容", SendOptions{}) {
+			if result.Err != nil {
+				t.Fatalf("unexpected error: %v", result.Err)
+			}
+		}
+	}
+	warned := map[string]int{}
+	for _, log := range logs {
+		if strings.Contains(log, "SkipTLSVerify") {
+			for _, server := range []*mockServer{first, second, verified} {
+				if strings.Contains(log, server.ln.Addr().String()) {
+					warned[server.ln.Addr().String()]++
+				}
+			}
+		}
+	}
+	if warned[first.ln.Addr().String()] != 1 || warned[second.ln.Addr().String()] != 1 || len(warned) != 2 {
+		t.Errorf("expected one warning per config with SkipTLSVerify, got %v", logs)
+	}
+
+	// SuppressInsecureWarning关闭警告
+	email = New(map[string]*ConfigMapper{"default": first.config(false)})
+	email.SuppressInsecureWarning = true
+	logs = nil
+	email.Logf = func(format string, args ...any) { logs = append(logs, fmt.Sprintf(format, args...)) }
+	email.SendWithOptions("", toList[:1], "测试主题", "测试内容", SendOptions{})
+	if len(logs) != 0 {
+		t.Errorf("expected no warning when suppressed, got %v", logs)
+	}
+}
+
+// TestEmail_ReplaceConfig tests swapping the configuration map while sends are in flight
+func TestEmail_ReplaceConfig(t *testing.T) {
+	oldServer := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	newServer := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	oldConfig, newConfig := oldServer.config(false), newServer.config(false)
+	email := New(map[string]*ConfigMapper{"default": oldConfig})
+
+	// 新配置无效时不替换
+	if err := email.ReplaceConfig(map[string]*ConfigMapper{"default": {Host: "smtp.example.com"}}); err == nil {
+		t.Fatal("expected an error for an invalid configuration")
+	}
+	if config, _ := email.GetMapper("a@example.com"); config != oldConfig {
+		t.Fatal("expected the old configuration to be kept after a failed replace")
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ports []int
+	start := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for range 5 {
+				results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "测试主题", "测试内容", SendOptions{})
+				if results[0].Err != nil {
+					t.Errorf("unexpected error: %v", results[0].Err)
+					return
+				}
+				mu.Lock()
+				ports = append(ports, results[0].Port)
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	if err := email.ReplaceConfig(map[string]*ConfigMapper{"default": newConfig}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(email.ConfigWarnings()) != 0 {
+		t.Errorf("unexpected warnings: %v", email.ConfigWarnings())
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, port := range ports {
+		counts[port]++
+	}
+	if counts[oldConfig.Port]+counts[newConfig.Port] != len(ports) {
+		t.Errorf("expected every send to use one of the two configurations, got %v", counts)
+	}
+	if counts[oldConfig.Port] != len(oldServer.received()) || counts[newConfig.Port] != len(newServer.received()) {
+		t.Errorf("results do not match delivered messages: %v, old %d, new %d", counts, len(oldServer.received()), len(newServer.received()))
+	}
+	sent := len(newServer.received())
+	email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	if len(newServer.received()) != sent+1 {
+		t.Errorf("expected sends after the swap to use the new configuration")
+	}
+}
+
+// TestEmail_TLSOverride tests a per-send TLS override without changing the stored config
+func TestEmail_TLSOverride(t *testing.T) {
+	server := (&mockServer{implicitTLS: true, extensions: []string{"AUTH PLAIN"}}).start(t)
+	config := server.config(true)
+	config.SkipTLSVerify = false
+	email := New(map[string]*ConfigMapper{"default": config})
+
+	// 同时发送：覆盖的发送跳过自签名证书的验证，其余发送仍然验证证书
+	var wg sync.WaitGroup
+	results := make([][]SendResult, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := SendOptions{}
+			if i%2 == 0 {
+				skip := true
+				opts.TLS = &TLSOverride{SkipTLSVerify: &skip}
+			}
+			results[i] = email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", opts)
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if i%2 == 0 && result[0].Err != nil {
+			t.Errorf("send %d: unexpected error with override: %v", i, result[0].Err)
+		}
+		if i%2 == 1 && result[0].Err == nil {
+			t.Errorf("send %d: expected certificate verification to fail without override", i)
+		}
+	}
+	if config.SkipTLSVerify {
+		t.Error("override must not change the stored config")
+	}
+}
+
+// TestEmail_TLSOverridePool tests that overridden sends keep unset fields and share pooled connections
+func TestEmail_TLSOverridePool(t *testing.T) {
+	server := (&mockServer{implicitTLS: true, extensions: []string{"AUTH PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(true)})
+	email.Pool = true
+	email.SuppressInsecureWarning = true
+	defer email.Close()
+
+	// 只覆盖ServerName时保留配置中的SkipTLSVerify，否则证书名称不匹配
+	opts := SendOptions{TLS: &TLSOverride{ServerName: "relay.example.com"}}
+	for range 3 {
+		if results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", opts); results[0].Err != nil {
+			t.Fatalf("unexpected error: %v", results[0].Err)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected overridden sends to share one pooled connection, got %d connections", got)
+	}
+}
+
+// TestEmail_ResultRelay tests that each result records the relay that delivered it
+func TestEmail_ResultRelay(t *testing.T) {
+	primary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	secondary := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{
+		"default":     primary.config(false),
+		"example.org": secondary.config(false),
+	})
+
+	results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}, {Address: "user@example.org"}},
+		"测试主题", "测试内容", SendOptions{})
+	for i, server := range []*mockServer{primary, secondary} {
+		addr := server.ln.Addr().(*net.TCPAddr)
+		if results[i].Err != nil {
+			t.Fatalf("%s: unexpected error: %v", results[i].Recipient.Address, results[i].Err)
+		}
+		if results[i].Host != "127.0.0.1" || results[i].Port != addr.Port {
+			t.Errorf("%s: expected relay 127.0.0.1:%d, got %s:%d", results[i].Recipient.Address, addr.Port, results[i].Host, results[i].Port)
+		}
+	}
+
+	batch := email.BatchSend(context.Background(), []*Message{{To: []mail.Address{{Address: "other@example.org"}}}})
+	if batch[0].Err != nil || batch[0].Port != secondary.ln.Addr().(*net.TCPAddr).Port {
+		t.Errorf("expected BatchSend result to record the secondary relay, got %+v", batch[0])
+	}
+
+	// 未能建立会话时不记录中继
+	secondary.ln.Close()
+	results = email.SendWithOptions("", []mail.Address{{Address: "user@example.org"}}, "测试主题", "测试内容", SendOptions{})
+	if results[0].Err == nil || results[0].Host != "" || results[0].Port != 0 {
+		t.Errorf("expected no relay for failed connection, got %+v", results[0])
+	}
+}
+
+// TestEmail_ClientCertificate tests mutual TLS with a relay that requires a client certificate
+func TestEmail_ClientCertificate(t *testing.T) {
+	certPEM, keyPEM := mockClientCertificate(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, implicitTLS := range []bool{true, false} {
+		server := (&mockServer{
+			implicitTLS:       implicitTLS,
+			extensions:        []string{"STARTTLS", "AUTH LOGIN PLAIN"},
+			requireClientCert: true,
+		}).start(t)
+		send := func(config *ConfigMapper) error {
+			config.OpportunisticTLS = !implicitTLS
+			email := New(map[string]*ConfigMapper{"default": config})
+			return email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})[0].Err
+		}
+
+		if err := send(server.config(implicitTLS)); err == nil {
+			t.Errorf("implicitTLS=%v: expected handshake to fail without client certificate", implicitTLS)
+		}
+		config := server.config(implicitTLS)
+		config.ClientCertFile, config.ClientKeyFile = certFile, keyFile
+		if err := send(config); err != nil {
+			t.Errorf("implicitTLS=%v: unexpected error with client certificate: %v", implicitTLS, err)
+		}
+		// 只使用证书认证，不发送AUTH
+		config = server.config(implicitTLS)
+		config.ClientCertPEM, config.ClientKeyPEM = certPEM, keyPEM
+		config.AuthType, config.Password = AuthNone, ""
+		if err := send(config); err != nil {
+			t.Errorf("implicitTLS=%v: unexpected error with certificate only: %v", implicitTLS, err)
+		}
+	}
+
+	config := &ConfigMapper{Host: "smtp.example.com", Port: 25, Username: "a@example.com", Password: "secret", ClientCertPEM: certPEM, ClientKeyPEM: keyPEM}
+	if err := config.Validate(); err == nil {
+		t.Error("expected error for client certificate without TLS")
+	}
+	config.TLS, config.ClientKeyPEM = true, []byte("invalid")
+	if err := config.Validate(); err == nil {
+		t.Error("expected error for invalid client key")
+	}
+
+	// 通过New添加的配置只在首次使用时加载证书，之后删除文件仍可使用；复制的配置共享缓存，修改证书字段后重新加载
+	config = &ConfigMapper{TLS: true, Host: "smtp.example.com", Port: 465, Username: "a@example.com", Password: "secret", ClientCertFile: certFile, ClientKeyFile: keyFile}
+	New(map[string]*ConfigMapper{"default": config})
+	first, err := clientCertificate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected the cached certificate to be used, got %v", err)
+	}
+	if cert, err := clientCertificate(config); err != nil || cert != first {
+		t.Errorf("expected the cached certificate, got %v %v", cert, err)
+	}
+	copied := *config
+	if cert, err := clientCertificate(&copied); err != nil || cert != first {
+		t.Errorf("expected the copy to share the cached certificate, got %v %v", cert, err)
+	}
+	config.ClientKeyFile = filepath.Join(dir, "other.key")
+	if err := config.Validate(); err == nil {
+		t.Error("expected the certificate to be reloaded after the key file changed")
+	}
+}
+
+// TestEmail_SuccessCode tests that any 2xx reply is accepted and the accepted codes are configurable
+func TestEmail_SuccessCode(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		extensions := []string{"AUTH LOGIN PLAIN"}
+		if pipelining {
+			extensions = append(extensions, "PIPELINING")
+		}
+		server := (&mockServer{
+			extensions: extensions,
+			reply: func(cmd string) string {
+				switch {
+				case strings.HasPrefix(cmd, "MAIL"):
+					return "251 sender ok via gateway"
+				case cmd == ".":
+					return "251 queued via gateway"
+				}
+				return ""
+			},
+		}).start(t)
+		config := server.config(false)
+		email := New(map[string]*ConfigMapper{"default": config})
+		results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+		if results[0].Err != nil {
+			t.Errorf("pipelining=%v: expected 251 to be treated as success, got %v", pipelining, results[0].Err)
+		}
+
+		config.SuccessCode = func(code int) bool { return code == 250 }
+		results = email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+		var smtpErr *SMTPError
+		if !errors.As(results[0].Err, &smtpErr) || smtpErr.Code != 251 {
+			t.Errorf("pipelining=%v: expected 251 to be rejected by SuccessCode, got %v", pipelining, results[0].Err)
+		}
+	}
+}
+
+// TestEmail_ReuseConnectionAfterFailure tests that a rejected transaction does not poison the shared connection
+func TestEmail_ReuseConnectionAfterFailure(t *testing.T) {
+	to := []mail.Address{{Address: "first@example.com"}, {Address: "second@example.com"}}
+	for _, tt := range []struct {
+		reject      string
+		connections int
+	}{
+		{"554 message rejected", 1},   // RSET后继续使用同一连接
+		{"421 closing connection", 2}, // 服务器要求重新连接
+	} {
+		messages := 0
+		server := (&mockServer{
+			extensions: []string{"AUTH LOGIN PLAIN"},
+			reply: func(cmd string) string {
+				if cmd == "." {
+					if messages++; messages == 1 {
+						return tt.reject
+					}
+				}
+				return ""
+			},
+		}).start(t)
+		email := New(map[string]*ConfigMapper{"default": server.config(false)})
+		email.ReuseConnection = true
+
+		results := email.SendWithOptions("", to, "测试主题", "测试内容", SendOptions{})
+		if results[0].Err == nil {
+			t.Errorf("%s: expected first recipient to fail", tt.reject)
+		}
+		if results[1].Err != nil {
+			t.Errorf("%s: unexpected error for second recipient: %v", tt.reject, results[1].Err)
+		}
+		if server.connections() != tt.connections {
+			t.Errorf("%s: expected %d connections, got %d", tt.reject, tt.connections, server.connections())
+		}
+		cmds := strings.Join(verbs(server.commands()), " ")
+		if tt.connections == 1 && !strings.Contains(cmds, "DATA RSET NOOP MAIL") {
+			t.Errorf("%s: expected RSET before the next transaction, got %s", tt.reject, cmds)
+		}
+	}
+}
+
+// TestEmail_MailSize tests declaring the message size on MAIL FROM when SIZE is advertised
+func TestEmail_MailSize(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		extensions := []string{"AUTH LOGIN PLAIN", "SIZE 10240000"}
+		if pipelining {
+			extensions = append(extensions, "PIPELINING")
+		}
+		server := (&mockServer{extensions: extensions}).start(t)
+		email := New(map[string]*ConfigMapper{"default": server.config(false)})
+		results := email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("pipelining=%v: unexpected error: %v", pipelining, results[0].Err)
+		}
+
+		received := server.received()
+		want := fmt.Sprintf("SIZE=%d", len(received[0]))
+		for _, cmd := range server.commands() {
+			if strings.HasPrefix(cmd, "MAIL FROM:") && !strings.HasSuffix(cmd, " "+want) {
+				t.Errorf("pipelining=%v: expected %s on MAIL FROM, got %q", pipelining, want, cmd)
+			}
+		}
+	}
+
+	// 服务器没有声明SIZE时不附加该参数
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "测试主题", "测试内容", SendOptions{})
+	for _, cmd := range server.commands() {
+		if strings.Contains(cmd, "SIZE=") {
+			t.Errorf("unexpected SIZE parameter: %q", cmd)
+		}
+	}
+}
+
+// TestEmail_DefaultHTML tests that DefaultHTML applies only when isHTML is omitted
+func TestEmail_DefaultHTML(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.DefaultHTML = true
+
+	to := []mail.Address{{Address: "a@example.com"}}
+	for _, send := range []func() []error{
+		func() []error { return email.Send("", to, "subject", "<p>content</p>") },
+		func() []error { return email.Send("", to, "subject", "content", false) },
+	} {
+		if errs := send(); len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+	received := server.received()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(received))
+	}
+	for i, want := range []string{"text/html", "text/plain"} {
+		header, _ := splitMessage(t, []byte(received[i]))
+		if !strings.Contains(header, "Content-Type: "+want+";") {
+			t.Errorf("message %d: expected %s content type in headers:\n%s", i, want, header)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SendEMLFile 读取RFC 5322格式的.eml文件并原样重新投递，用于重发归档的邮件
+// recipients为空时使用文件中To、Cc和Bcc头的地址作为信封收件人；文件内容不经过中间件，
+// 除删除Bcc头以免泄露密送收件人外不做任何修改，开启BinaryMIME时也不转换附件的编码。
+// 开启LoopDetection时按信封收件人检查Delivered-To和Received头，检测到环路时不发送，但不添加Delivered-To头。
+// 收件人按配置分组，同一配置的收件人共享一个连接；返回结果与信封收件人一一对应，
+// 文件无法读取或没有邮件头时返回只有一个元素的结果
+func (m *Email) SendEMLFile(ctx context.Context, path string, recipients []mail.Address) []SendResult {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []SendResult{{Err: fmt.Errorf("email: failed to read eml file: %w", err)}}
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return []SendResult{{Err: fmt.Errorf("email: invalid eml file %s: %w", path, err)}}
+	}
+	if len(msg.Header) == 0 {
+		return []SendResult{{Err: fmt.Errorf("email: invalid eml file %s: no header section", path)}}
+	}
+	if len(recipients) == 0 {
+		for _, key := range []string{"To", "Cc", "Bcc"} {
+			list, err := msg.Header.AddressList(key)
+			if err != nil && !errors.Is(err, mail.ErrHeaderNotPresent) {
+				return []SendResult{{Err: fmt.Errorf("email: invalid eml file %s: header %s: %w", path, key, err)}}
+			}
+			for _, addr := range list {
+				recipients = append(recipients, *addr)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return []SendResult{{Err: ErrNoRecipients}}
+	}
+	if err := m.checkRecipientCount(len(recipients)); err != nil {
+		return failAll(recipients, err)
+	}
+	// From头缺失或无法解析时使用配置的默认信封发件人
+	var from mail.Address
+	if list, err := msg.Header.AddressList("From"); err == nil && len(list) > 0 {
+		from = *list[0]
+	}
+
+	data = stripBcc(data)
+
+	recipients = m.rewriteRecipients(recipients)
+	if m.LoopDetection {
+		// 只检测环路，不向文件内容添加Delivered-To头
+		if err := m.checkLoop(&Message{Header: textproto.MIMEHeader(msg.Header)}, recipients); err != nil {
+			return failAll(recipients, err)
+		}
+	}
+	results := make([]SendResult, len(recipients))
+	configs, groups := m.route(recipients, results, nil)
+	var wg sync.WaitGroup
+	sem := newLimiter(m.MaxConcurrency)
+	for _, config := range configs {
+		wg.Add(1)
+		go func(config *ConfigMapper, indexes []int) {
+			defer wg.Done()
+			sem.acquire()
+			defer sem.release()
+
+			err := ctx.Err()
+			if err != nil {
+				err = notAttempted(err)
+			} else if from.Address != "" {
+				err = m.checkFrom(config, from)
+			}
+			if err != nil {
+				for _, i := range indexes {
+					results[i].Err = err
+				}
+				return
+			}
+			m.deliverGroup(ctx, config, from.Address, results, indexes, data, true)
+		}(config, groups[config])
+	}
+	wg.Wait()
+	return results
+}
+
+// stripBcc 删除邮件头中的Bcc字段及其折行（RFC 5322 3.6.3节），其余内容逐字节保持不变
+func stripBcc(data []byte) []byte {
+	var out bytes.Buffer
+	rest := data
+	skipping := false
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// 空行之后为正文
+			out.Write(line)
+			out.Write(rest)
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := bytes.Cut(line, []byte(":"))
+			skipping = strings.EqualFold(string(bytes.TrimSpace(name)), "Bcc")
+		}
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,153 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sampleEML 归档的邮件，包含折行的邮件头和非UTF-8的正文
+const sampleEML = "From: \"Archive\" <archive@example.com>\r\n" +
+	"To: <user@example.com>, <other@example.org>\r\n" +
+	"Subject: =?utf-8?q?=E6=9C=88=E6=8A=A5?=\r\n" +
+	"Date: Mon, 02 Mar 2026 10:00:00 +0800\r\n" +
+	"Message-ID: <20260302.1@example.com>\r\n" +
+	"X-Original-Header: folded\r\n" +
+	" continuation\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=GB18030\r\n" +
+	"Content-Transfer-Encoding: 8bit\r\n" +
+	"\r\n" +
+	"\xd4\xc2\xb1\xa8\r\n" +
+	"second line\r\n"
+
+// TestEmail_SendEMLFile tests resending an archived file unchanged to its own or explicit recipients
+func TestEmail_SendEMLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archived.eml")
+	if err := os.WriteFile(path, []byte(sampleEML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	// 未指定收件人时使用文件中的To头
+	results := email.SendEMLFile(context.Background(), path, nil)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", result.Recipient.Address, result.Err)
+		}
+	}
+	received := server.received()
+	if len(received) != 1 || received[0] != sampleEML {
+		t.Errorf("expected file content delivered intact, got %q", received)
+	}
+	var envelope []string
+	for _, cmd := range server.commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") || strings.HasPrefix(cmd, "RCPT TO:") {
+			envelope = append(envelope, strings.Fields(cmd)[1])
+		}
+	}
+	if want := "FROM:<sender@example.com>,TO:<user@example.com>,TO:<other@example.org>"; strings.Join(envelope, ",") != want {
+		t.Errorf("expected envelope %s, got %q", want, envelope)
+	}
+
+	// 指定收件人时只投递给这些地址
+	results = email.SendEMLFile(context.Background(), path, []mail.Address{{Address: "resend@example.net"}})
+	if len(results) != 1 || results[0].Err != nil || results[0].Recipient.Address != "resend@example.net" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.eml")
+	if err := os.WriteFile(invalid, []byte("not a message"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if results := email.SendEMLFile(context.Background(), invalid, nil); results[0].Err == nil {
+		t.Error("expected error for file without header section")
+	}
+}
+
+// TestEmail_SendEMLFileRaw tests that resending keeps base64 parts under BinaryMIME and strips the Bcc header
+func TestEmail_SendEMLFileRaw(t *testing.T) {
+	body := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b1\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attachment\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=a.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"AAECAw==\r\n" +
+		"--b1--\r\n"
+	eml := "From: <archive@example.com>\r\n" +
+		"To: <user@example.com>\r\n" +
+		"Bcc: <hidden@example.com>,\r\n" +
+		" <other@example.com>\r\n" +
+		"Subject: report\r\n" + body
+	path := filepath.Join(t.TempDir(), "archived.eml")
+	if err := os.WriteFile(path, []byte(eml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "BINARYMIME", "CHUNKING"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.BinaryMIME = true
+
+	results := email.SendEMLFile(context.Background(), path, nil)
+	if len(results) != 3 {
+		t.Fatalf("expected the Bcc addresses as envelope recipients, got %+v", results)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", result.Recipient.Address, result.Err)
+		}
+	}
+	want := "From: <archive@example.com>\r\nTo: <user@example.com>\r\nSubject: report\r\n" + body
+	if received := server.received(); len(received) != 1 || received[0] != want {
+		t.Errorf("expected the file without Bcc and with its base64 part unchanged, got %q", received)
+	}
+	if cmds := verbs(server.commands()); slices.Contains(cmds, "BDAT") {
+		t.Errorf("expected the raw file to be sent with DATA, got %q", cmds)
+	}
+}
+
+// TestEmail_SendEMLFileLoopDetection tests that a looped file is not resent and a clean file is sent unchanged
+func TestEmail_SendEMLFileLoopDetection(t *testing.T) {
+	dir := t.TempDir()
+	looped := filepath.Join(dir, "looped.eml")
+	if err := os.WriteFile(looped, []byte("Delivered-To: user@example.com\r\n"+sampleEML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clean := filepath.Join(dir, "clean.eml")
+	if err := os.WriteFile(clean, []byte(sampleEML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN", "8BITMIME"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.LoopDetection = true
+
+	results := email.SendEMLFile(context.Background(), looped, []mail.Address{{Address: "user@example.com"}})
+	if len(results) != 1 || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "loop") {
+		t.Fatalf("expected a loop error, got %+v", results)
+	}
+	if server.connections() != 0 {
+		t.Errorf("expected no connections, got %d", server.connections())
+	}
+
+	results = email.SendEMLFile(context.Background(), clean, []mail.Address{{Address: "user@example.com"}})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if received := server.received(); len(received) != 1 || received[0] != sampleEML {
+		t.Errorf("expected the file delivered without Delivered-To, got %q", received)
+	}
+}
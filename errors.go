@@ -0,0 +1,59 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 常见错误，发送结果中的错误包装了这些错误，可以用errors.Is判断
+var (
+	// ErrNoRecipients 没有收件人
+	ErrNoRecipients = errors.New("email: no recipients")
+	// ErrNoConfig 收件人没有可用的配置，包括StrictRouting下域名没有对应配置
+	ErrNoConfig = errors.New("email: no configuration")
+	// ErrInvalidConfig 收件人选中的配置无效，同时包装具体原因
+	ErrInvalidConfig = errors.New("email: invalid configuration")
+	// ErrUnencrypted 认证需要加密连接，但连接未加密
+	ErrUnencrypted = errors.New("email: server requires encrypted connection")
+	// ErrTooManyRejections 被拒绝的收件人超过MaxRejectedRecipients或MaxRejectedRatio，邮件没有发送
+	ErrTooManyRejections = errors.New("email: too many recipients rejected, message not sent")
+	// ErrTooManyRecipients 一次发送的收件人总数超过MaxRecipientsPerSend，整次发送被拒绝
+	ErrTooManyRecipients = errors.New("email: too many recipients")
+	// ErrRecipientNotAllowed 收件人的域名不在AllowedDomains中或在DeniedDomains中，没有发送
+	ErrRecipientNotAllowed = errors.New("email: recipient domain not allowed")
+	// ErrResponseTooLong 服务器的响应行超过ConfigMapper.MaxResponseLineLength
+	ErrResponseTooLong = errors.New("email: server response line too long")
+	// ErrQuitFailed 邮件已被服务器接受，但QUIT失败；邮件已投递，SendResult.Retryable返回false
+	ErrQuitFailed = errors.New("email: QUIT failed after the message was accepted")
+)
+
+// sentinelError 保留原有的错误信息，同时可以用errors.Is判断对应的常见错误
+type sentinelError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *sentinelError) Error() string { return e.msg }
+
+func (e *sentinelError) Unwrap() error { return e.sentinel }
+
+// wrapSentinel 生成包装sentinel的错误，错误信息按format格式化
+func wrapSentinel(sentinel error, format string, args ...any) error {
+	return &sentinelError{msg: fmt.Sprintf(format, args...), sentinel: sentinel}
+}
+
+// authError 认证失败，RetryPolicy.AuthRetry据此判断是否重新建立连接
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string { return "authentication failed: " + e.err.Error() }
+
+func (e *authError) Unwrap() error { return e.err }
+
+// permanentAuthFailure 判断错误是否为服务器以5xx拒绝的认证
+func permanentAuthFailure(err error) bool {
+	var authErr *authError
+	var smtpErr *SMTPError
+	return errors.As(err, &authErr) && errors.As(authErr.err, &smtpErr) && smtpErr.Code >= 500
+}
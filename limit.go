@@ -0,0 +1,105 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// defaultMaxResponseLine 服务器单行响应默认允许的最大字节数
+const defaultMaxResponseLine = 8 << 10
+
+// maxResponseLine 返回配置允许的响应行长度
+func maxResponseLine(config *ConfigMapper) int {
+	if config.MaxResponseLineLength > 0 {
+		return config.MaxResponseLineLength
+	}
+	return defaultMaxResponseLine
+}
+
+// lineLimiter 限制读取内容中每行的长度，超过max时返回ErrResponseTooLong，max为0时不限制
+type lineLimiter struct {
+	r    io.Reader
+	max  int
+	line int
+	err  error
+}
+
+func (l *lineLimiter) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	if l.max <= 0 {
+		return n, err
+	}
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.line = 0
+			continue
+		}
+		if l.line++; l.line > l.max {
+			l.err = ErrResponseTooLong
+			return i, l.err
+		}
+	}
+	return n, err
+}
+
+// limitedConn 通过lineLimiter读取的连接
+type limitedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// limitResponses 限制客户端读取的响应行长度
+// 标准库在STARTTLS后会替换客户端的Text，因此STARTTLS后需要重新设置
+func limitResponses(smtpClient *smtp.Client, config *ConfigMapper) {
+	reader := &smtpClient.Text.Reader
+	reader.R = bufio.NewReader(&lineLimiter{r: reader.R, max: maxResponseLine(config)})
+}
+
+// commandConn 每次写入命令后将读取截止时间设为timeout之后，使每个响应都在timeout内读完
+// 读取截止时间不晚于通过SetDeadline设置的会话截止时间。读取超时后连接不再可用：
+// 未读完的响应会被误认为后续命令的响应（标准库在EHLO失败后会改用HELO），因此之后的读写都返回该超时错误
+type commandConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+	err      error
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.Conn.Read(p)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.Conn.Write(p)
+	readDeadline := time.Now().Add(c.timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(readDeadline) {
+		readDeadline = c.deadline
+	}
+	_ = c.Conn.SetReadDeadline(readDeadline)
+	return n, err
+}
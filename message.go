@@ -0,0 +1,705 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 正文传输编码（Content-Transfer-Encoding）
+const (
+	EncodingDefault         = ""                 // 正文包含非ASCII字符时使用quoted-printable，否则使用7bit
+	Encoding7Bit            = "7bit"             // 纯ASCII正文，不做转换
+	Encoding8Bit            = "8bit"             // 允许8位字符，不做转换
+	EncodingQuotedPrintable = "quoted-printable" // quoted-printable编码
+	EncodingBase64          = "base64"           // base64编码
+)
+
+// SendOptions 单次发送的选项
+type SendOptions struct {
+	// HTML 是否发送HTML格式邮件，默认false（纯文本）
+	HTML bool
+	// Text HTML邮件的纯文本替代内容，HTML为true且Text非空时正文使用multipart/alternative格式
+	Text string
+	// TransferEncoding 正文传输编码，见EncodingDefault等常量
+	TransferEncoding string
+	// Attachments 附件，存在附件时邮件使用multipart/mixed格式
+	Attachments []*Attachment
+	// Parts 预先构建的MIME部分，原样追加在正文和附件之后，存在时邮件使用multipart/mixed格式
+	Parts []*MIMEPart
+	// ContentLanguage 正文语言，写入Content-Language头，如"zh-CN"
+	ContentLanguage string
+	// ContentType 正文的内容类型，如"text/markdown; charset=UTF-8"，非空时原样使用并忽略HTML和Text
+	ContentType string
+	// Preheader HTML邮件的预览文本，以隐藏的span插入HTML正文开头，收件箱列表中显示为摘要
+	Preheader string
+	// HeadersFor 为每个收件人生成额外的邮件头，如各自的List-Unsubscribe链接；
+	// 邮件头在序列化时检查，包含换行等非法内容时该收件人发送失败
+	HeadersFor func(mail.Address) map[string]string
+	// HeaderTo 写入To头的地址，为空时To头为收件人本身；
+	// 用于邮件列表等To头显示列表地址、实际投递给每个订阅者的场景
+	HeaderTo []mail.Address
+	// ReplyTo 写入Reply-To头的地址，可以有多个，收件人回复时发往这些地址
+	ReplyTo []mail.Address
+	// TLS 只对本次发送生效的TLS设置，覆盖所选配置中的SkipTLSVerify等字段，为nil时使用配置本身
+	TLS *TLSOverride
+
+	// via 由SendVia指定的配置，不为nil时不按域名选择配置
+	via *ConfigMapper
+}
+
+// optionsOf 将可选的isHTML参数转换为发送选项，未传入isHTML时使用DefaultHTML
+func (m *Email) optionsOf(isHTML []bool) SendOptions {
+	if len(isHTML) == 0 {
+		return SendOptions{HTML: m.DefaultHTML}
+	}
+	return SendOptions{HTML: isHTML[0]}
+}
+
+// Auto-Submitted头的取值（RFC 3834）
+const (
+	AutoGenerated = "auto-generated" // 程序自动生成的邮件，如通知、验证码
+	AutoReplied   = "auto-replied"   // 对收到邮件的自动回复，如休假回复
+)
+
+// defaultMailer 默认的X-Mailer头，标识发送邮件的软件及版本
+const defaultMailer = "liu-dc/email 1.0.0"
+
+// MIMEPart 预先构建的MIME部分，用于高层API无法表达的结构，如S/MIME签名数据或自定义multipart类型
+// 序列化时Header和Body原样写入，不做任何编码：Body必须已按Header中的Content-Transfer-Encoding编码，
+// multipart类型的Body须自带边界
+type MIMEPart struct {
+	Header textproto.MIMEHeader
+	Body   []byte
+}
+
+// Message 序列化前的邮件，中间件可以修改其中的内容
+type Message struct {
+	From mail.Address
+	To   AddressList
+	// Cc 抄送收件人，写入Cc头；BatchSend同时向其投递
+	Cc AddressList
+	// Bcc 密送收件人，只用于投递，不写入任何邮件头
+	Bcc AddressList
+	// ReplyTo 写入Reply-To头的地址，可以有多个；From只能有一个地址
+	ReplyTo AddressList
+	// Envelope 信封收件人，非空时BatchSend在RCPT TO中使用这些地址，而不是To、Cc和Bcc
+	Envelope []mail.Address
+	Subject  string
+	// Body 正文内容，HTML为true时为HTML格式
+	Body string
+	// HTML 正文是否为HTML格式
+	HTML bool
+	// Text HTML正文的纯文本替代内容，HTML为true且Text非空时正文使用multipart/alternative格式
+	Text string
+	// TransferEncoding 正文传输编码，见EncodingDefault等常量
+	TransferEncoding string
+	// Attachments 附件，存在附件时邮件使用multipart/mixed格式
+	Attachments []*Attachment
+	// Parts 预先构建的MIME部分，原样追加在正文和附件之后，存在时邮件使用multipart/mixed格式
+	Parts []*MIMEPart
+	// ContentLanguage 正文语言，写入Content-Language头，如"zh-CN"
+	ContentLanguage string
+	// ContentType 正文的内容类型，非空时原样使用并忽略HTML和Text
+	ContentType string
+	// Preheader HTML正文的预览文本，见SendOptions.Preheader
+	Preheader string
+	// Report 投递状态通知，非nil时邮件使用multipart/report格式，正文作为供人阅读的说明部分，不能包含附件
+	Report *DeliveryReport
+	// Header 额外的邮件头，序列化时追加在标准邮件头之后
+	Header textproto.MIMEHeader
+	// Priority BatchSend中的发送优先级，数值大的先投递，相同优先级保持原有顺序；只影响发送顺序，不写入邮件头
+	Priority int
+}
+
+// buildMessage 构建邮件，依次执行中间件后序列化
+func (m *Email) buildMessage(from mail.Address, to []mail.Address, subject, content string, opts SendOptions) ([]byte, error) {
+	return m.prepare(newMessage(from, to, subject, content, opts), to)
+}
+
+// newMessage 根据发送选项创建邮件
+func newMessage(from mail.Address, to []mail.Address, subject, content string, opts SendOptions) *Message {
+	return &Message{
+		From:             from,
+		To:               to,
+		Subject:          subject,
+		Body:             content,
+		HTML:             opts.HTML,
+		Text:             opts.Text,
+		TransferEncoding: opts.TransferEncoding,
+		Attachments:      opts.Attachments,
+		Parts:            opts.Parts,
+		ContentLanguage:  opts.ContentLanguage,
+		ContentType:      opts.ContentType,
+		ReplyTo:          opts.ReplyTo,
+		Preheader:        opts.Preheader,
+		Header:           make(textproto.MIMEHeader),
+	}
+}
+
+// setOriginalTo 将被改写的收件人的原地址写入X-Original-To头
+func setOriginalTo(msg *Message, originals, rewritten []mail.Address) {
+	for i := range originals {
+		if !strings.EqualFold(originals[i].Address, rewritten[i].Address) {
+			if msg.Header == nil {
+				msg.Header = make(textproto.MIMEHeader)
+			}
+			msg.Header.Add("X-Original-To", originals[i].Address)
+		}
+	}
+}
+
+// prepare 在邮件副本上执行中间件和环路检测后序列化，不修改调用方传入的邮件
+// envelope为这封邮件实际投递的信封收件人，用于环路检测
+func (m *Email) prepare(original *Message, envelope []mail.Address) ([]byte, error) {
+	msg := *original
+	msg.Header = make(textproto.MIMEHeader, len(original.Header))
+	for key, values := range original.Header {
+		msg.Header[key] = append([]string(nil), values...)
+	}
+	if !m.DisableMailer && !m.Privacy && msg.Header.Get("X-Mailer") == "" {
+		mailer := m.Mailer
+		if mailer == "" {
+			mailer = defaultMailer
+		}
+		msg.Header.Set("X-Mailer", mailer)
+	}
+	if m.AutoSubmitted != "" && msg.Header.Get("Auto-Submitted") == "" {
+		msg.Header.Set("Auto-Submitted", m.AutoSubmitted)
+	}
+
+	for _, middleware := range m.Middleware {
+		if err := middleware(&msg); err != nil {
+			return nil, err
+		}
+	}
+	m.stripHeaders(msg.Header)
+	if strings.TrimSpace(msg.Subject) == "" {
+		switch {
+		case m.DefaultSubject != "":
+			msg.Subject = m.DefaultSubject
+		case m.RejectEmptySubject:
+			return nil, ErrEmptySubject
+		}
+	}
+	if m.LoopDetection {
+		if err := m.checkLoop(&msg, envelope); err != nil {
+			return nil, err
+		}
+	}
+	if m.MaxBodySize > 0 {
+		var err error
+		if msg.Body, err = m.limitBody(msg.Body); err != nil {
+			return nil, err
+		}
+		if msg.Text, err = m.limitBody(msg.Text); err != nil {
+			return nil, err
+		}
+	}
+	if m.MaxTotalAttachmentSize > 0 {
+		if size := attachmentsSize(msg.Attachments, msg.Parts); size > m.MaxTotalAttachmentSize {
+			return nil, fmt.Errorf("%w: encoded size %d bytes exceeds limit of %d", ErrAttachmentsTooLarge, size, m.MaxTotalAttachmentSize)
+		}
+	}
+	if !m.Force7Bit {
+		return serialize(&msg, m.date(), m.random())
+	}
+
+	force7Bit(&msg)
+	message, err := serialize(&msg, m.date(), m.random())
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range message {
+		if b >= utf8.RuneSelf {
+			return nil, fmt.Errorf("email: message is not 7bit clean: byte 0x%02x at offset %d", b, i)
+		}
+	}
+	return message, nil
+}
+
+// force7Bit 将邮件头中的非ASCII内容按RFC 2047编码，8bit正文改用quoted-printable编码
+// 主题、显示名称和附件参数在序列化时已经编码；无法编码的内容（如非ASCII的邮箱地址）由调用方检查
+func force7Bit(msg *Message) {
+	for _, values := range msg.Header {
+		for i, value := range values {
+			values[i] = mime.QEncoding.Encode("UTF-8", value)
+		}
+	}
+	if msg.TransferEncoding == Encoding8Bit {
+		msg.TransferEncoding = EncodingQuotedPrintable
+	}
+}
+
+// ErrBodyTooLarge 正文超过MaxBodySize且未开启TruncateBody
+var ErrBodyTooLarge = errors.New("email: body too large")
+
+// ErrEmptySubject 主题为空且开启了RejectEmptySubject
+var ErrEmptySubject = errors.New("email: empty subject")
+
+// ErrAttachmentsTooLarge 附件编码后的总大小超过MaxTotalAttachmentSize
+var ErrAttachmentsTooLarge = errors.New("email: attachments too large")
+
+// attachmentsSize 计算附件在邮件中占用的字节数：附件按base64编码计算（约增加33%，另加每76个字符的CRLF），
+// 预先构建的MIME部分按Body的长度计算，不含各部分的头
+func attachmentsSize(attachments []*Attachment, parts []*MIMEPart) int {
+	size := 0
+	for _, attachment := range attachments {
+		encoded := base64.StdEncoding.EncodedLen(len(attachment.Data))
+		size += encoded + max(encoded-1, 0)/76*2
+	}
+	for _, part := range parts {
+		size += len(part.Body)
+	}
+	return size
+}
+
+// truncateNotice 正文被截断时附加的提示
+const truncateNotice = "\n\n[message truncated]"
+
+// limitBody 检查正文大小，超过MaxBodySize时按TruncateBody截断或返回ErrBodyTooLarge
+// 截断位置向前调整到完整的UTF-8字符边界，截断后的正文加上提示不超过MaxBodySize
+func (m *Email) limitBody(body string) (string, error) {
+	if len(body) <= m.MaxBodySize {
+		return body, nil
+	}
+	if !m.TruncateBody {
+		return "", fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrBodyTooLarge, len(body), m.MaxBodySize)
+	}
+	cut := max(m.MaxBodySize-len(truncateNotice), 0)
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return body[:cut] + truncateNotice, nil
+}
+
+// defaultMaxHops 默认允许的最大Received头数量（RFC 5321 6.3节）
+const defaultMaxHops = 100
+
+// checkLoop 检测转发环路：邮件已投递给过envelope中的信封收件人，或经过的中继数超过上限
+// 通过检查后为信封收件人添加Delivered-To头，供后续的转发环节检测；多个收件人共享同一封邮件时
+// 只添加出现在To或Cc头中的收件人，避免泄露密送地址
+func (m *Email) checkLoop(msg *Message, envelope []mail.Address) error {
+	maxHops := m.MaxHops
+	if maxHops <= 0 {
+		maxHops = defaultMaxHops
+	}
+	if hops := len(msg.Header.Values("Received")); hops > maxHops {
+		return fmt.Errorf("email: mail loop detected: %d hops exceeds limit of %d", hops, maxHops)
+	}
+	for _, delivered := range msg.Header.Values("Delivered-To") {
+		for _, to := range envelope {
+			if strings.EqualFold(strings.TrimSpace(delivered), to.Address) {
+				return fmt.Errorf("email: mail loop detected: already delivered to %s", to.Address)
+			}
+		}
+	}
+	visible := func(addr mail.Address) bool {
+		match := func(header mail.Address) bool { return strings.EqualFold(header.Address, addr.Address) }
+		return slices.ContainsFunc(msg.To, match) || slices.ContainsFunc(msg.Cc, match)
+	}
+	for _, to := range envelope {
+		if len(envelope) == 1 || visible(to) {
+			msg.Header.Add("Delivered-To", to.Address)
+		}
+	}
+	return nil
+}
+
+// writeHeader 写入额外的邮件头，拒绝包含换行的值以防止邮件头注入
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) error {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, ": \t\r\n") {
+			return fmt.Errorf("email: invalid header name %q", key)
+		}
+		for _, value := range header[key] {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("email: invalid value for header %s", key)
+			}
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	return nil
+}
+
+// checkSingleFrom 检查邮件只有一个From地址：From.Address不能是地址列表，Header中不能再有From；
+// ReplyTo非空时Header中不能再有Reply-To，否则邮件会出现重复的邮件头
+func checkSingleFrom(msg *Message) error {
+	if list, err := mail.ParseAddressList(msg.From.Address); err == nil && len(list) > 1 {
+		return errors.New("email: multiple From addresses are not allowed, use ReplyTo instead")
+	}
+	if _, ok := msg.Header["From"]; ok {
+		return errors.New("email: multiple From addresses are not allowed, use ReplyTo instead")
+	}
+	if _, ok := msg.Header["Reply-To"]; ok && len(msg.ReplyTo) > 0 {
+		return errors.New("email: Reply-To is set both in ReplyTo and Header")
+	}
+	return nil
+}
+
+// serialize 将邮件序列化为可直接投递的内容，date为Date头的值，random为生成multipart边界使用的随机源
+// 邮件头中没有Message-ID时使用random生成，random为nil时使用crypto/rand
+// 主题包含换行时返回错误以防止邮件头注入，包含非ASCII字符时按RFC 2047编码
+// 邮件总是包含MIME-Version头，正文的Content-Type和Content-Transfer-Encoding依赖该头
+// 存在附件或预先构建的MIME部分时邮件为multipart/mixed，正文作为第一个部分；
+// 正文同时有纯文本和HTML版本时为multipart/alternative，纯文本在前
+func serialize(msg *Message, date string, random io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To.Format("To"))
+	if len(msg.Cc) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", msg.Cc.Format("Cc"))
+	}
+	if err := checkSingleFrom(msg); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From.String())
+	if len(msg.ReplyTo) > 0 {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", msg.ReplyTo.Format("Reply-To"))
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("email: invalid value for header Subject")
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\nDate: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject), date)
+	if msg.Header.Get("Message-ID") == "" {
+		id, err := messageID(msg.From.Address, random)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "Message-ID: %s\r\n", id)
+	}
+	if err := writeHeader(&buf, msg.Header); err != nil {
+		return nil, err
+	}
+
+	header, body, err := bodyPart(msg, random)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case msg.Report != nil:
+		if len(msg.Attachments) > 0 || len(msg.Parts) > 0 {
+			return nil, errors.New("email: delivery report must not have attachments")
+		}
+		if header, body, err = reportPart(header, body, msg.Report, random); err != nil {
+			return nil, err
+		}
+	case len(msg.Attachments) > 0 || len(msg.Parts) > 0:
+		if header, body, err = mixedPart(header, body, msg.Attachments, msg.Parts, random); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if msg.ContentLanguage != "" {
+		if strings.ContainsAny(msg.ContentLanguage, "\r\n") {
+			return nil, errors.New("email: invalid value for header Content-Language")
+		}
+		fmt.Fprintf(&buf, "Content-Language: %s\r\n", msg.ContentLanguage)
+	}
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n", header.Get("Content-Type"))
+	if encoding := header.Get("Content-Transfer-Encoding"); encoding != "" {
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: %s\r\n", encoding)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// preheaderStyle 隐藏预览文本的内联样式，兼顾不支持display:none的邮件客户端（如Outlook的mso-hide）
+const preheaderStyle = "display:none!important;visibility:hidden;mso-hide:all;font-size:1px;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;"
+
+// withPreheader 在HTML正文开头插入隐藏的预览文本：正文包含<body>标签时插入在标签之后，否则插入在最前面
+func withPreheader(body, preheader string) string {
+	span := `<span style="` + preheaderStyle + `">` + html.EscapeString(preheader) + `</span>`
+	for i := 0; i+len("<body") < len(body); i++ {
+		if !strings.EqualFold(body[i:i+len("<body")], "<body") || !strings.ContainsRune("> \t\r\n", rune(body[i+len("<body")])) {
+			continue
+		}
+		if end := strings.IndexByte(body[i:], '>'); end >= 0 {
+			at := i + end + 1
+			return body[:at] + span + body[at:]
+		}
+		break
+	}
+	return span + body
+}
+
+// bodyPart 生成正文部分的头和内容；HTML正文带有纯文本替代内容时生成multipart/alternative
+// 指定了ContentType时正文为单个部分，内容类型原样使用
+func bodyPart(msg *Message, random io.Reader) (textproto.MIMEHeader, []byte, error) {
+	if msg.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(msg.ContentType)
+		if err != nil || strings.ContainsAny(msg.ContentType, "\r\n") {
+			return nil, nil, fmt.Errorf("email: invalid content type %q", msg.ContentType)
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil, nil, fmt.Errorf("email: content type %q must not be multipart", msg.ContentType)
+		}
+		header, body, err := textPart(msg.Body, false, msg.TransferEncoding)
+		if err != nil {
+			return nil, nil, err
+		}
+		header.Set("Content-Type", msg.ContentType)
+		return header, body, nil
+	}
+	content := msg.Body
+	if msg.HTML && msg.Preheader != "" {
+		content = withPreheader(content, msg.Preheader)
+	}
+	if !msg.HTML || msg.Text == "" {
+		return textPart(content, msg.HTML, msg.TransferEncoding)
+	}
+
+	var parts []MIMEPart
+	for _, alternative := range []struct {
+		content string
+		html    bool
+	}{{msg.Text, false}, {content, true}} {
+		header, body, err := textPart(alternative.content, alternative.html, msg.TransferEncoding)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, MIMEPart{Header: header, Body: body})
+	}
+	boundary, body, err := writeMultipart(parts, random)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": boundary})},
+	}
+	return header, body, nil
+}
+
+// textPart 按传输编码生成纯文本或HTML部分
+func textPart(content string, html bool, encoding string) (textproto.MIMEHeader, []byte, error) {
+	encoding, body, err := encodeBody(content, encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType(html)},
+		"Content-Transfer-Encoding": {encoding},
+	}
+	return header, []byte(body), nil
+}
+
+// mixedPart 生成multipart/mixed内容，正文作为第一个部分，之后依次为附件和预先构建的MIME部分
+func mixedPart(bodyHeader textproto.MIMEHeader, body []byte, attachments []*Attachment, raw []*MIMEPart, random io.Reader) (textproto.MIMEHeader, []byte, error) {
+	parts := []MIMEPart{{Header: bodyHeader, Body: body}}
+	for _, attachment := range attachments {
+		parts = append(parts, MIMEPart{
+			Header: textproto.MIMEHeader{
+				"Content-Type":              {attachmentType(attachment)},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+				"Content-Transfer-Encoding": {EncodingBase64},
+			},
+			Body: []byte(attachment.encodedData()),
+		})
+	}
+	for _, part := range raw {
+		if err := checkPartHeader(part.Header); err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, *part)
+	}
+	boundary, content, err := writeMultipart(parts, random)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary})},
+	}
+	return header, content, nil
+}
+
+// messageID 生成Message-ID头的值（RFC 5322 3.6.4节）：左侧为从random读取的16字节的十六进制，
+// 右侧为发件人地址的域名，没有域名时为localhost；random为nil时使用crypto/rand
+func messageID(from string, random io.Reader) (string, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	var id [16]byte
+	if _, err := io.ReadFull(random, id[:]); err != nil {
+		return "", fmt.Errorf("email: failed to generate Message-ID: %w", err)
+	}
+	domain := "localhost"
+	if at := strings.LastIndexByte(from, '@'); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%x@%s>", id, domain), nil
+}
+
+// random 返回构建邮件使用的随机源，Rand为nil时返回nil
+// 并发构建的邮件共享Rand，每次读取在锁内读满，同一个Message-ID或边界的字节不会与其他邮件交错
+func (m *Email) random() io.Reader {
+	if m.Rand == nil {
+		return nil
+	}
+	return lockedReader{mu: &m.randMu, r: m.Rand}
+}
+
+// lockedReader 在锁内读取r
+type lockedReader struct {
+	mu *sync.Mutex
+	r  io.Reader
+}
+
+func (l lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return io.ReadFull(l.r, p)
+}
+
+// checkPartHeader 检查预先构建的MIME部分的头，头名称或值非法时返回错误
+func checkPartHeader(header textproto.MIMEHeader) error {
+	for key, values := range header {
+		if key == "" || strings.ContainsAny(key, ": \t\r\n") {
+			return fmt.Errorf("email: invalid MIME part header name %q", key)
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("email: invalid value for MIME part header %s", key)
+			}
+		}
+	}
+	return nil
+}
+
+// attachmentType 生成附件部分的Content-Type，保留内容类型中已有的参数并追加name参数
+func attachmentType(attachment *Attachment) string {
+	mediaType, params, err := mime.ParseMediaType(attachment.mediaType())
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	params["name"] = attachment.Filename
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// privacyHeaders 隐私模式下移除的邮件头，可能泄露发件人的IP和客户端信息
+var privacyHeaders = []string{"X-Originating-IP", "X-Mailer", "User-Agent"}
+
+// stripHeaders 移除StripHeaders中列出的邮件头，开启Privacy时同时移除privacyHeaders
+func (m *Email) stripHeaders(header textproto.MIMEHeader) {
+	for _, key := range m.StripHeaders {
+		header.Del(key)
+	}
+	if m.Privacy {
+		for _, key := range privacyHeaders {
+			header.Del(key)
+		}
+	}
+}
+
+// date 生成RFC 5322格式的Date头，时间取自Now（未设置时为当前时间），使用DateLocation指定的时区，开启Privacy时使用UTC
+func (m *Email) date() string {
+	now := time.Now()
+	if m.Now != nil {
+		now = m.Now()
+	}
+	if m.Privacy {
+		now = now.UTC()
+	} else if m.DateLocation != nil {
+		now = now.In(m.DateLocation)
+	}
+	return now.Format(time.RFC1123Z)
+}
+
+// fromAddress 生成发件人地址，fromName为空时使用配置中的FromName，
+// 地址为配置中的FromAddress，未设置时为Username
+func fromAddress(config *ConfigMapper, fromName string) mail.Address {
+	if fromName == "" {
+		fromName = config.FromName
+	}
+	address := config.FromAddress
+	if address == "" {
+		address = config.Username
+	}
+	return mail.Address{
+		Name:    fromName,
+		Address: address,
+	}
+}
+
+// contentType 生成正文的内容类型
+func contentType(html bool) string {
+	if html {
+		return "text/html; charset=UTF-8"
+	}
+	return "text/plain; charset=UTF-8"
+}
+
+// isASCII 判断内容是否只包含7位ASCII字符
+func isASCII(content string) bool {
+	for i := 0; i < len(content); i++ {
+		if content[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeBody 按传输编码转换正文，返回实际使用的编码和编码后的正文
+func encodeBody(content, encoding string) (string, string, error) {
+	if encoding == EncodingDefault {
+		encoding = Encoding7Bit
+		if !isASCII(content) {
+			encoding = EncodingQuotedPrintable
+		}
+	}
+
+	switch encoding {
+	case Encoding7Bit:
+		if !isASCII(content) {
+			return "", "", errors.New("email: content is not 7bit clean")
+		}
+		return encoding, content, nil
+	case Encoding8Bit:
+		return encoding, content, nil
+	case EncodingQuotedPrintable:
+		var buf bytes.Buffer
+		w := quotedprintable.NewWriter(&buf)
+		if _, err := w.Write([]byte(content)); err != nil {
+			return "", "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", "", err
+		}
+		return encoding, buf.String(), nil
+	case EncodingBase64:
+		return encoding, wrapBase64(base64.StdEncoding.EncodeToString([]byte(content))), nil
+	default:
+		return "", "", fmt.Errorf("email: unsupported transfer encoding %q", encoding)
+	}
+}
+
+// wrapBase64 按RFC 2045要求将base64内容按每行76个字符折行
+func wrapBase64(encoded string) string {
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	return b.String()
+}
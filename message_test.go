@@ -0,0 +1,812 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// splitMessage 将邮件拆分为头部和正文
+func splitMessage(t *testing.T, message []byte) (string, string) {
+	header, body, ok := strings.Cut(string(message), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator:\n%s", message)
+	}
+	return header, body
+}
+
+// TestBuildMessage_TransferEncoding tests each Content-Transfer-Encoding choice
+func TestBuildMessage_TransferEncoding(t *testing.T) {
+	from := mail.Address{Name: "发件人", Address: "sender@example.com"}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+	content := "你好，世界 = hello"
+
+	tests := []struct {
+		name     string
+		encoding string
+		content  string
+		want     string
+		decode   func(string) string
+	}{
+		{"default ascii", EncodingDefault, "hello world", Encoding7Bit, nil},
+		{"default non-ascii", EncodingDefault, content, EncodingQuotedPrintable, decodeQP},
+		{"7bit", Encoding7Bit, "hello world", Encoding7Bit, nil},
+		{"8bit", Encoding8Bit, content, Encoding8Bit, nil},
+		{"quoted-printable", EncodingQuotedPrintable, content, EncodingQuotedPrintable, decodeQP},
+		{"base64", EncodingBase64, strings.Repeat(content, 10), EncodingBase64, decodeBase64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := (&Email{}).buildMessage(from, to, "主题", tt.content, SendOptions{TransferEncoding: tt.encoding})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			header, body := splitMessage(t, message)
+			if !strings.Contains(header, "\r\nContent-Transfer-Encoding: "+tt.want) {
+				t.Errorf("expected %s encoding header, got:\n%s", tt.want, header)
+			}
+			if tt.decode == nil {
+				if body != tt.content {
+					t.Errorf("expected unchanged body %q, got %q", tt.content, body)
+				}
+				return
+			}
+			if body == tt.content {
+				t.Errorf("expected body to be transformed, got %q", body)
+			}
+			for _, line := range strings.Split(body, "\r\n") {
+				if len(line) > 76 || !isASCII(line) {
+					t.Errorf("encoded line is not 7bit or exceeds 76 chars: %q", line)
+				}
+			}
+			if got := tt.decode(body); got != tt.content {
+				t.Errorf("decoded body mismatch: got %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+// TestBuildMessage_Invalid7Bit tests rejecting non-ASCII content with 7bit encoding
+func TestBuildMessage_Invalid7Bit(t *testing.T) {
+	_, err := (&Email{}).buildMessage(mail.Address{Address: "sender@example.com"}, nil, "主题", "你好", SendOptions{TransferEncoding: Encoding7Bit})
+	if err == nil {
+		t.Error("expected error for non-ASCII content with 7bit encoding")
+	}
+}
+
+func decodeQP(body string) string {
+	decoded, _ := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	return string(decoded)
+}
+
+func decodeBase64(body string) string {
+	decoded, _ := base64.StdEncoding.DecodeString(strings.ReplaceAll(body, "\r\n", ""))
+	return string(decoded)
+}
+
+// TestBuildMessage_DateLocation tests formatting the Date header in the configured timezone
+func TestBuildMessage_DateLocation(t *testing.T) {
+	loc := time.FixedZone("CST", 8*60*60)
+	email := &Email{DateLocation: loc}
+	message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	date := msg.Header.Get("Date")
+	if !strings.HasSuffix(date, " +0800") {
+		t.Errorf("expected +0800 offset, got %q", date)
+	}
+	parsed, err := msg.Header.Date()
+	if err != nil {
+		t.Fatalf("Date header is not RFC 5322 compliant: %v", err)
+	}
+	if _, offset := parsed.Zone(); offset != 8*60*60 {
+		t.Errorf("expected offset of 8 hours, got %d seconds", offset)
+	}
+}
+
+// TestBuildMessage_Privacy tests a UTC Date header and stripping headers that leak sender details
+func TestBuildMessage_Privacy(t *testing.T) {
+	email := &Email{Privacy: true, DateLocation: time.FixedZone("CST", 8*60*60), StripHeaders: []string{"x-campaign-host"}}
+	email.Middleware = append(email.Middleware, func(msg *Message) error {
+		msg.Header.Set("X-Campaign-Host", "build-01.internal")
+		msg.Header.Set("X-Originating-IP", "[192.0.2.1]")
+		msg.Header.Set("User-Agent", "Thunderbird")
+		msg.Header.Set("X-Priority", "1")
+		return nil
+	})
+	message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if date := msg.Header.Get("Date"); !strings.HasSuffix(date, " +0000") {
+		t.Errorf("expected a UTC Date header, got %q", date)
+	}
+	for _, key := range []string{"X-Originating-IP", "User-Agent", "X-Mailer", "X-Campaign-Host"} {
+		if value := msg.Header.Get(key); value != "" {
+			t.Errorf("expected %s to be stripped, got %q", key, value)
+		}
+	}
+	if msg.Header.Get("X-Priority") != "1" {
+		t.Errorf("expected unrelated headers to be kept")
+	}
+}
+
+// TestBuildMessage_EmptySubject tests rejecting, substituting and passing through an empty subject
+func TestBuildMessage_EmptySubject(t *testing.T) {
+	from, to := mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}}
+
+	if _, err := (&Email{RejectEmptySubject: true}).buildMessage(from, to, " ", "内容", SendOptions{}); !errors.Is(err, ErrEmptySubject) {
+		t.Errorf("expected ErrEmptySubject, got %v", err)
+	}
+	for _, tc := range []struct {
+		email *Email
+		want  string
+	}{
+		{&Email{DefaultSubject: "(无主题)", RejectEmptySubject: true}, "Subject: " + mime.QEncoding.Encode("UTF-8", "(无主题)") + "\r\n"},
+		{&Email{}, "Subject: \r\n"},
+	} {
+		message, err := tc.email.buildMessage(from, to, "", "内容", SendOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if header, _ := splitMessage(t, message); !strings.Contains(header+"\r\n", tc.want) {
+			t.Errorf("expected %q in headers:\n%s", tc.want, header)
+		}
+	}
+}
+
+// TestBuildMessage_LoopDetection tests refusing messages already delivered to the recipient
+func TestBuildMessage_LoopDetection(t *testing.T) {
+	email := &Email{LoopDetection: true, MaxHops: 2}
+	from := mail.Address{Address: "relay@example.com"}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+	header := func(key string, values ...string) func(*Message) error {
+		return func(msg *Message) error {
+			for _, value := range values {
+				msg.Header.Add(key, value)
+			}
+			return nil
+		}
+	}
+
+	email.Middleware = []func(*Message) error{header("Delivered-To", "RCPT@example.com")}
+	if _, err := email.buildMessage(from, to, "主题", "内容", SendOptions{}); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("expected loop error for existing Delivered-To, got %v", err)
+	}
+
+	email.Middleware = []func(*Message) error{header("Received", "from a", "from b", "from c")}
+	if _, err := email.buildMessage(from, to, "主题", "内容", SendOptions{}); err == nil || !strings.Contains(err.Error(), "hops") {
+		t.Errorf("expected loop error for too many hops, got %v", err)
+	}
+
+	email.Middleware = []func(*Message) error{header("Delivered-To", "other@example.com")}
+	message, err := email.buildMessage(from, to, "主题", "内容", SendOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(message), "\r\nDelivered-To: rcpt@example.com\r\n") {
+		t.Errorf("expected Delivered-To header to be inserted:\n%s", message)
+	}
+}
+
+// TestBuildMessage_AddressList tests formatting the To and Cc headers
+func TestBuildMessage_AddressList(t *testing.T) {
+	msg := &Message{
+		From: mail.Address{Address: "sender@example.com"},
+		To: []mail.Address{
+			{Name: "张三", Address: "zhang@example.com"},
+			{Address: "bare@example.com"},
+			{Name: "Doe, John", Address: "john@example.com"},
+		},
+		Cc:      []mail.Address{{Address: "cc@example.com"}, {Name: "Copy", Address: "copy@example.com"}},
+		Subject: "subject",
+		Body:    "content",
+	}
+	message, err := (&Email{}).prepare(msg, msg.To)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+
+	for key, want := range map[string][]mail.Address{"To": msg.To, "Cc": msg.Cc} {
+		got, err := parsed.Header.AddressList(key)
+		if err != nil {
+			t.Fatalf("failed to parse %s header %q: %v", key, parsed.Header.Get(key), err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d %s addresses, got %d", len(want), key, len(got))
+		}
+		for i := range want {
+			if got[i].Name != want[i].Name || got[i].Address != want[i].Address {
+				t.Errorf("%s[%d]: expected %v, got %v", key, i, want[i], got[i])
+			}
+		}
+	}
+}
+
+// TestBuildMessage_AlternativeWithAttachments tests nesting multipart/alternative inside multipart/mixed
+func TestBuildMessage_AlternativeWithAttachments(t *testing.T) {
+	message, err := (&Email{}).buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"report", "<p>hello</p>", SendOptions{
+			HTML:        true,
+			Text:        "hello",
+			Attachments: []*Attachment{NewAttachment("a.pdf", []byte("a")), NewAttachment("b.csv", []byte("b"))},
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("expected MIME-Version header")
+	}
+
+	// readParts 读取multipart内容，返回每个部分的内容类型和内容
+	readParts := func(contentType string, body io.Reader, wantType string) ([]string, [][]byte) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != wantType {
+			t.Fatalf("expected %s, got %q (%v)", wantType, contentType, err)
+		}
+		var types []string
+		var contents [][]byte
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return types, contents
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			content, _ := io.ReadAll(part)
+			types = append(types, part.Header.Get("Content-Type"))
+			contents = append(contents, content)
+		}
+	}
+
+	types, contents := readParts(msg.Header.Get("Content-Type"), msg.Body, "multipart/mixed")
+	if len(types) != 3 {
+		t.Fatalf("expected alternative part and 2 attachments, got %q", types)
+	}
+	if !strings.HasPrefix(types[1], "application/pdf") || !strings.HasPrefix(types[2], "text/csv") {
+		t.Errorf("expected attachments after the body, got %q", types)
+	}
+	altTypes, altContents := readParts(types[0], strings.NewReader(string(contents[0])), "multipart/alternative")
+	if len(altTypes) != 2 || !strings.HasPrefix(altTypes[0], "text/plain") || !strings.HasPrefix(altTypes[1], "text/html") {
+		t.Fatalf("expected text/plain then text/html alternatives, got %q", altTypes)
+	}
+	if string(altContents[0]) != "hello" || string(altContents[1]) != "<p>hello</p>" {
+		t.Errorf("unexpected alternative contents %q", altContents)
+	}
+}
+
+// TestBuildMessage_MIMEHeaders tests MIME-Version and Content-Language headers
+func TestBuildMessage_MIMEHeaders(t *testing.T) {
+	from := mail.Address{Address: "sender@example.com"}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+
+	plain, err := (&Email{}).buildMessage(from, to, "subject", "content", SendOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header, _ := splitMessage(t, plain)
+	if !strings.Contains(header, "MIME-Version: 1.0\r\n") {
+		t.Errorf("expected MIME-Version on single-part message:\n%s", header)
+	}
+	if strings.Contains(header, "Content-Language") {
+		t.Errorf("expected no Content-Language by default:\n%s", header)
+	}
+
+	multi, err := (&Email{}).buildMessage(from, to, "subject", "内容", SendOptions{
+		ContentLanguage: "zh-CN",
+		Attachments:     []*Attachment{NewAttachment("a.txt", []byte("a"))},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(multi)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if got := msg.Header.Get("MIME-Version"); got != "1.0" {
+		t.Errorf("expected MIME-Version 1.0 on multipart message, got %q", got)
+	}
+	if got := msg.Header.Get("Content-Language"); got != "zh-CN" {
+		t.Errorf("expected Content-Language zh-CN, got %q", got)
+	}
+
+	if _, err = (&Email{}).buildMessage(from, to, "subject", "content", SendOptions{ContentLanguage: "en\r\nBcc: x@example.com"}); err == nil {
+		t.Error("expected error for Content-Language containing CRLF")
+	}
+}
+
+// TestBuildMessage_ContentType tests overriding the body Content-Type
+func TestBuildMessage_ContentType(t *testing.T) {
+	from := mail.Address{Address: "sender@example.com"}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+
+	message, err := (&Email{}).buildMessage(from, to, "notes", "# Title\n\n*text*", SendOptions{
+		HTML:        true,
+		ContentType: "text/markdown; charset=UTF-8",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header, body := splitMessage(t, message)
+	if !strings.Contains(header, "\r\nContent-Type: text/markdown; charset=UTF-8\r\n") {
+		t.Errorf("expected exact Content-Type header:\n%s", header)
+	}
+	if body != "# Title\n\n*text*" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	for _, contentType := range []string{"text/", "multipart/mixed; boundary=x", "text/plain\r\nBcc: x@example.com"} {
+		if _, err := (&Email{}).buildMessage(from, to, "s", "c", SendOptions{ContentType: contentType}); err == nil {
+			t.Errorf("expected error for content type %q", contentType)
+		}
+	}
+}
+
+// TestBuildMessage_MaxBodySize tests rejecting and truncating bodies over MaxBodySize
+func TestBuildMessage_MaxBodySize(t *testing.T) {
+	from := mail.Address{Address: "sender@example.com"}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+	content := strings.Repeat("正文", 100)
+
+	m := &Email{MaxBodySize: 64}
+	if _, err := m.buildMessage(from, to, "subject", content, SendOptions{TransferEncoding: Encoding8Bit}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected ErrBodyTooLarge, got %v", err)
+	}
+	if _, err := m.buildMessage(from, to, "subject", "short", SendOptions{}); err != nil {
+		t.Fatalf("unexpected error for body within limit: %v", err)
+	}
+
+	m.TruncateBody = true
+	message, err := m.buildMessage(from, to, "subject", content, SendOptions{TransferEncoding: Encoding8Bit})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, body := splitMessage(t, message)
+	if len(body) > 64 || !strings.HasSuffix(body, "[message truncated]") {
+		t.Errorf("expected body truncated to 64 bytes with notice, got %d bytes %q", len(body), body)
+	}
+	if !utf8.ValidString(body) || !strings.HasPrefix(content, strings.TrimSuffix(body, "\n\n[message truncated]")) {
+		t.Errorf("expected truncation at a character boundary, got %q", body)
+	}
+}
+
+// TestBuildMessage_Mailer tests the default, overridden and suppressed X-Mailer header
+func TestBuildMessage_Mailer(t *testing.T) {
+	mailer := func(email *Email) (string, bool) {
+		t.Helper()
+		message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+			"主题", "内容", SendOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+		if err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		values, ok := msg.Header["X-Mailer"]
+		return strings.Join(values, ","), ok
+	}
+
+	if got, _ := mailer(&Email{}); got != defaultMailer {
+		t.Errorf("expected default X-Mailer %q, got %q", defaultMailer, got)
+	}
+	if got, _ := mailer(&Email{Mailer: "acme-notify 2.3"}); got != "acme-notify 2.3" {
+		t.Errorf("expected overridden X-Mailer, got %q", got)
+	}
+	if got, ok := mailer(&Email{DisableMailer: true}); ok {
+		t.Errorf("expected no X-Mailer header, got %q", got)
+	}
+}
+
+// TestBuildMessage_AutoSubmitted tests the Auto-Submitted header is added only when configured
+func TestBuildMessage_AutoSubmitted(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{AutoGenerated, "auto-generated"},
+		{AutoReplied, "auto-replied"},
+	} {
+		email := &Email{AutoSubmitted: tt.value}
+		message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+			"主题", "内容", SendOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+		if err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		values, ok := msg.Header["Auto-Submitted"]
+		if tt.want == "" && ok {
+			t.Errorf("expected no Auto-Submitted header by default, got %q", values)
+		}
+		if tt.want != "" && (len(values) != 1 || values[0] != tt.want) {
+			t.Errorf("expected Auto-Submitted %q, got %q", tt.want, values)
+		}
+	}
+}
+
+// TestBuildMessage_ReplyTo tests a Reply-To header with several addresses
+func TestBuildMessage_ReplyTo(t *testing.T) {
+	email := &Email{}
+	message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{ReplyTo: []mail.Address{
+			{Name: "客服", Address: "support@example.com"},
+			{Address: "sales@example.com"},
+		}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 两个地址超过78个字符，在地址之间折行
+	want := "Reply-To: =?utf-8?q?=E5=AE=A2=E6=9C=8D?= <support@example.com>,\r\n <sales@example.com>\r\n"
+	if !strings.Contains(string(message), want) {
+		t.Errorf("expected header %q, got:\n%s", want, message)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	list, err := msg.Header.AddressList("Reply-To")
+	if err != nil || len(list) != 2 || list[0].Name != "客服" || list[1].Address != "sales@example.com" {
+		t.Errorf("unexpected Reply-To %v: %v", list, err)
+	}
+}
+
+// TestBuildMessage_MultipleFrom tests that a second From address is rejected
+func TestBuildMessage_MultipleFrom(t *testing.T) {
+	email := &Email{}
+	to := []mail.Address{{Address: "rcpt@example.com"}}
+	if _, err := email.buildMessage(mail.Address{Address: "a@example.com, b@example.com"}, to, "主题", "内容", SendOptions{}); err == nil {
+		t.Error("expected error for From address list")
+	}
+	email.Middleware = append(email.Middleware, func(msg *Message) error {
+		msg.Header.Add("From", "b@example.com")
+		return nil
+	})
+	_, err := email.buildMessage(mail.Address{Address: "a@example.com"}, to, "主题", "内容", SendOptions{})
+	if err == nil || !strings.Contains(err.Error(), "multiple From") {
+		t.Errorf("expected multiple From error, got %v", err)
+	}
+}
+
+// TestBuildMessage_RawPart tests that a pre-built MIME part is emitted unchanged
+func TestBuildMessage_RawPart(t *testing.T) {
+	signature := "MIAGCSqGSIb3DQEHAqCAMIACAQEx\r\nDzANBglghkgBZQMEAgEFADCABgkq\r\n"
+	part := &MIMEPart{
+		Header: textproto.MIMEHeader{
+			"Content-Type":              {`application/pkcs7-signature; name="smime.p7s"`},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="smime.p7s"`},
+			"X-Custom":                  {"first", "second"},
+		},
+		Body: []byte(signature),
+	}
+	message, err := (&Email{}).buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{Parts: []*MIMEPart{part}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Content-Disposition: attachment; filename=\"smime.p7s\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+		"X-Custom: first\r\n" +
+		"X-Custom: second\r\n" +
+		"\r\n" + signature
+	if !strings.Contains(string(message), want) {
+		t.Errorf("expected part to be emitted unchanged:\n%s", message)
+	}
+	if header, _ := splitMessage(t, message); !strings.Contains(header, "Content-Type: multipart/mixed") {
+		t.Errorf("expected multipart/mixed message:\n%s", header)
+	}
+
+	part.Header.Set("X-Custom", "bad\r\nBcc: victim@example.com")
+	if _, err := (&Email{}).buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{Parts: []*MIMEPart{part}}); err == nil {
+		t.Error("expected error for header injection in MIME part")
+	}
+}
+
+// TestBuildMessage_Preheader tests the hidden preview text at the start of the HTML body
+func TestBuildMessage_Preheader(t *testing.T) {
+	for _, tt := range []struct {
+		body, prefix string
+	}{
+		{"<p>正文</p>", ""},
+		{`<html><BODY class="main"><p>正文</p></BODY></html>`, `<html><BODY class="main">`},
+	} {
+		msg := &Message{Body: tt.body, HTML: true, Text: "正文", Preheader: "本周优惠 <限时>"}
+		header, body, err := bodyPart(msg, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		mr := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+		var html string
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+				data, _ := io.ReadAll(quotedprintable.NewReader(part))
+				html = string(data)
+			}
+		}
+		want := tt.prefix + `<span style="display:none!important;visibility:hidden;mso-hide:all;`
+		if !strings.HasPrefix(html, want) || !strings.Contains(html, ">本周优惠 &lt;限时&gt;</span>") {
+			t.Errorf("expected hidden preheader at the start of the HTML body, got %q", html)
+		}
+	}
+
+	// 纯文本邮件不插入预览文本
+	_, body, err := bodyPart(&Message{Body: "正文", Preheader: "预览"}, nil)
+	if err != nil || strings.Contains(string(body), "span") {
+		t.Errorf("expected no preheader for plain text, got %q (%v)", body, err)
+	}
+}
+
+// TestBuildMessage_MaxTotalAttachmentSize tests rejecting attachments whose encoded size exceeds the limit
+func TestBuildMessage_MaxTotalAttachmentSize(t *testing.T) {
+	attachments := []*Attachment{
+		NewAttachment("a.bin", make([]byte, 600)),
+		NewAttachment("b.bin", make([]byte, 300)),
+	}
+	encoded := 0
+	for _, attachment := range attachments {
+		encoded += len(attachment.encodedData())
+	}
+	if size := attachmentsSize(attachments, nil); size != encoded {
+		t.Errorf("expected encoded size %d, got %d", encoded, size)
+	}
+
+	// 原始大小900字节未超过上限，编码后超过
+	email := &Email{MaxTotalAttachmentSize: 1000}
+	_, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{Attachments: attachments})
+	if !errors.Is(err, ErrAttachmentsTooLarge) || !strings.Contains(err.Error(), "exceeds limit of 1000") {
+		t.Errorf("expected ErrAttachmentsTooLarge, got %v", err)
+	}
+
+	email.MaxTotalAttachmentSize = encoded
+	if _, err = email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{Attachments: attachments}); err != nil {
+		t.Errorf("unexpected error at the limit: %v", err)
+	}
+}
+
+// TestBuildMessage_Rand tests that an injected random source produces a stable Message-ID and MIME boundaries
+func TestBuildMessage_Rand(t *testing.T) {
+	build := func() (*mail.Message, string) {
+		// 依次生成Message-ID、multipart/alternative的边界和multipart/mixed的边界
+		random := slices.Concat(bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0xab}, 30), bytes.Repeat([]byte{0xcd}, 30))
+		email := &Email{Rand: bytes.NewReader(random)}
+		message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+			"报告", "<p>请查收</p>", SendOptions{HTML: true, Text: "请查收", Attachments: []*Attachment{NewAttachment("a.txt", []byte("data"))}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(message))
+		if err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		body, _ := io.ReadAll(msg.Body)
+		return msg, string(body)
+	}
+	msg, body := build()
+	if got, want := msg.Header.Get("Message-ID"), "<"+strings.Repeat("01", 16)+"@example.com>"; got != want {
+		t.Errorf("expected Message-ID %q, got %q", want, got)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("invalid Content-Type: %v", err)
+	}
+	if want := strings.Repeat("cd", 30); params["boundary"] != want {
+		t.Errorf("expected boundary %q, got %q", want, params["boundary"])
+	}
+	if want := "boundary=" + strings.Repeat("ab", 30); !strings.Contains(body, want) {
+		t.Errorf("expected the alternative part to use %q", want)
+	}
+	if _, again := build(); again != body {
+		t.Errorf("expected identical bodies with the same random source")
+	}
+
+	// 邮件头已有Message-ID时不重新生成
+	email := &Email{Rand: bytes.NewReader(nil)}
+	email.Middleware = append(email.Middleware, func(msg *Message) error {
+		msg.Header.Set("Message-ID", "<fixed@example.com>")
+		return nil
+	})
+	message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}}, "报告", "内容", SendOptions{})
+	if err != nil || strings.Count(string(message), "Message-Id: <fixed@example.com>\r\n") != 1 {
+		t.Errorf("expected the existing Message-ID to be kept, got %v:\n%s", err, message)
+	}
+
+	// 随机源不足时返回错误而不是退回crypto/rand
+	email = &Email{Rand: bytes.NewReader(nil)}
+	if _, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"报告", "内容", SendOptions{Attachments: []*Attachment{NewAttachment("a.txt", []byte("data"))}}); err == nil {
+		t.Error("expected an error for an exhausted random source")
+	}
+}
+
+// TestBuildMessage_Golden tests the exact bytes of a multipart message built with a fixed clock and random source
+func TestBuildMessage_Golden(t *testing.T) {
+	random := slices.Concat(bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0xab}, 30), bytes.Repeat([]byte{0xcd}, 30))
+	email := &Email{
+		Rand:         bytes.NewReader(random),
+		Now:          func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+		DateLocation: time.UTC,
+		Mailer:       "golden",
+	}
+	message, err := email.buildMessage(mail.Address{Name: "Sender", Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"Report", "<p>Hello</p>", SendOptions{HTML: true, Text: "Hello", Attachments: []*Attachment{NewAttachment("a.txt", []byte("data"))}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mixed, alternative := strings.Repeat("cd", 30), strings.Repeat("ab", 30)
+	want := "To: <rcpt@example.com>\r\n" +
+		"From: \"Sender\" <sender@example.com>\r\n" +
+		"Subject: Report\r\n" +
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"Message-ID: <" + strings.Repeat("01", 16) + "@example.com>\r\n" +
+		"X-Mailer: golden\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=" + mixed + "\r\n" +
+		"\r\n" +
+		"--" + mixed + "\r\n" +
+		"Content-Type: multipart/alternative; boundary=" + alternative + "\r\n" +
+		"\r\n" +
+		"--" + alternative + "\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--" + alternative + "\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"\r\n" +
+		"<p>Hello</p>\r\n" +
+		"--" + alternative + "--\r\n" +
+		"\r\n" +
+		"--" + mixed + "\r\n" +
+		"Content-Disposition: attachment; filename=a.txt\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: text/plain; charset=utf-8; name=a.txt\r\n" +
+		"\r\n" +
+		"ZGF0YQ==\r\n" +
+		"--" + mixed + "--\r\n"
+	if string(message) != want {
+		t.Errorf("unexpected message:\ngot:\n%q\nwant:\n%q", message, want)
+	}
+}
+
+// TestBuildMessage_BoundaryCollision tests that a boundary found in the encoded content is regenerated
+func TestBuildMessage_BoundaryCollision(t *testing.T) {
+	collision := strings.Repeat("ab", 30)
+	random := slices.Concat(bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0xab}, 30), bytes.Repeat([]byte{0xcd}, 30))
+	email := &Email{Rand: bytes.NewReader(random)}
+	part := &MIMEPart{Header: textproto.MIMEHeader{"Content-Type": {"text/plain"}}, Body: []byte("--" + collision + "\r\n")}
+	message, err := email.buildMessage(mail.Address{Address: "sender@example.com"}, []mail.Address{{Address: "rcpt@example.com"}},
+		"主题", "内容", SendOptions{Parts: []*MIMEPart{part}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if want := strings.Repeat("cd", 30); params["boundary"] != want {
+		t.Fatalf("expected the colliding boundary to be replaced with %q, got %q", want, params["boundary"])
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(p)
+		bodies = append(bodies, string(body))
+	}
+	if len(bodies) != 2 || bodies[1] != string(part.Body) {
+		t.Errorf("expected the raw part to be kept intact, got %q", bodies)
+	}
+}
+
+// TestEmail_RandConcurrent tests that concurrent sends sharing Rand read it safely; run with -race
+func TestEmail_RandConcurrent(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Rand = bytes.NewReader(bytes.Repeat([]byte{0x5a, 0xa5, 0x3c}, 1000))
+
+	toList := make([]mail.Address, 8)
+	for i := range toList {
+		toList[i] = mail.Address{Address: fmt.Sprintf("user%d@example.com", i)}
+	}
+	for _, result := range email.SendWithOptions("", toList, "主题", "内容", SendOptions{Attachments: []*Attachment{NewAttachment("a.txt", []byte("data"))}}) {
+		if result.Err != nil {
+			t.Fatalf("unexpected error for %s: %v", result.Recipient.Address, result.Err)
+		}
+	}
+	if received := server.received(); len(received) != len(toList) {
+		t.Errorf("expected %d messages, got %d", len(toList), len(received))
+	}
+}
+
+// TestEmail_LoopDetectionEnvelope tests that loop detection uses the envelope recipients rather than the To header
+func TestEmail_LoopDetectionEnvelope(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.LoopDetection = true
+	email.Middleware = []func(*Message) error{func(msg *Message) error {
+		msg.Header.Add("Delivered-To", "looped@example.com")
+		return nil
+	}}
+
+	// HeaderTo显示列表地址时按实际的订阅者检测
+	list := []mail.Address{{Address: "list@example.com"}}
+	results := email.SendWithOptions("", []mail.Address{{Address: "looped@example.com"}, {Address: "fresh@example.com"}},
+		"主题", "内容", SendOptions{HeaderTo: list})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "loop") {
+		t.Errorf("expected a loop error for the subscriber, got %v", results[0].Err)
+	}
+	if results[1].Err != nil {
+		t.Fatalf("unexpected error: %v", results[1].Err)
+	}
+	if header, _ := splitMessage(t, []byte(server.received()[0])); !strings.Contains(header, "Delivered-To: fresh@example.com\r\n") {
+		t.Errorf("expected Delivered-To for the envelope recipient:\n%s", header)
+	}
+
+	// SendGroup为每个收件人添加Delivered-To
+	email.Middleware = nil
+	for _, result := range email.SendGroup("", []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}}, "主题", "内容") {
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+	}
+	header, _ := splitMessage(t, []byte(server.received()[1]))
+	for _, want := range []string{"Delivered-To: a@example.com\r\n", "Delivered-To: b@example.com\r\n"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("expected %q in headers:\n%s", want, header)
+		}
+	}
+}
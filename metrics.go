@@ -0,0 +1,21 @@
+package email
+
+import "time"
+
+// ConnectMetrics 建立一个连接各阶段的耗时，用于判断发送缓慢是网络、加密还是服务器认证造成的
+// 连接在某个阶段失败时，之后各阶段的耗时为0
+type ConnectMetrics struct {
+	// Addr 服务器地址，格式为host:port
+	Addr string
+	// Dial TCP连接的耗时
+	Dial time.Duration
+	// TLSHandshake TLS握手的耗时：TLS=true时为连接建立后的握手，STARTTLS时包括STARTTLS命令
+	// 和握手后重新发送的EHLO；未加密时为0
+	TLSHandshake time.Duration
+	// Auth 身份验证的耗时，未配置凭据时为0
+	Auth time.Duration
+	// Total 从开始连接到完成身份验证（或失败）的总耗时，包括读取问候和EHLO
+	Total time.Duration
+	// Err 建立连接失败的原因，成功时为nil
+	Err error
+}
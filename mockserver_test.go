@@ -0,0 +1,386 @@
+package email
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	mockCertOnce sync.Once
+	mockCert     tls.Certificate
+)
+
+// mockCertificate 生成测试用的自签名证书
+func mockCertificate(t *testing.T) tls.Certificate {
+	mockCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			DNSNames:     []string{"localhost"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %v", err)
+		}
+		mockCert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	return mockCert
+}
+
+// mockClientCertificate 生成测试用的PEM格式客户端证书和私钥
+func mockClientCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// mockServer 测试用SMTP服务器，记录收到的命令和邮件内容
+type mockServer struct {
+	// extensions EHLO响应中声明的扩展
+	extensions []string
+	// tlsExtensions 加密后EHLO响应中声明的扩展，为nil时使用extensions（去掉STARTTLS）
+	tlsExtensions []string
+	// implicitTLS 连接建立后直接进行TLS握手
+	implicitTLS bool
+	// pipelined 收到MAIL后暂存响应，直到收到DATA才一并发出；
+	// 客户端若等待MAIL的响应再发送后续命令将会超时
+	pipelined bool
+	// drop 接受连接后立即关闭的连接数，用于模拟连接被重置
+	drop int
+	// greetingDelay 接受连接后延迟发送220问候的时间，用于模拟tarpitting
+	greetingDelay time.Duration
+	// lineDelay EHLO多行响应中每行之间的间隔，用于模拟缓慢的服务器
+	lineDelay time.Duration
+	// requireClientCert TLS握手时要求客户端出示证书
+	requireClientCert bool
+	// tlsDelay TLS握手前的延迟，用于模拟缓慢的加密握手
+	tlsDelay time.Duration
+	// authDelay 响应AUTH前的延迟，用于模拟缓慢的服务器认证
+	authDelay time.Duration
+	// idleTimeout 等待命令的最长时间，超时后发送421并关闭连接，0表示不限制
+	idleTimeout time.Duration
+	// certificate TLS握手出示的证书链，为nil时使用mockCertificate
+	certificate *tls.Certificate
+	// reply 自定义命令响应，返回空字符串时使用默认响应；
+	// 邮件内容结束时以"."作为命令调用
+	reply func(cmd string) string
+
+	ln       net.Listener
+	mu       sync.Mutex
+	cmds     []string
+	messages []string
+	conns    int
+	upgrades int
+}
+
+// start 启动服务器，测试结束时自动关闭
+func (s *mockServer) start(t *testing.T) *mockServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s.ln = ln
+	t.Cleanup(func() { _ = ln.Close() })
+
+	certificate := mockCertificate(t)
+	if s.certificate != nil {
+		certificate = *s.certificate
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if s.requireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			drop := s.drop > 0
+			if drop {
+				s.drop--
+			}
+			s.mu.Unlock()
+			if drop {
+				_ = conn.Close()
+				continue
+			}
+			go s.serve(conn, tlsConfig)
+		}
+	}()
+	return s
+}
+
+// config 返回指向该服务器的配置
+func (s *mockServer) config(useTLS bool) *ConfigMapper {
+	return &ConfigMapper{
+		TLS:           useTLS,
+		Host:          "127.0.0.1",
+		Port:          s.ln.Addr().(*net.TCPAddr).Port,
+		Username:      "sender@example.com",
+		Password:      "secret",
+		SkipTLSVerify: true,
+	}
+}
+
+func (s *mockServer) serve(conn net.Conn, tlsConfig *tls.Config) {
+	defer func() { _ = conn.Close() }()
+	secure := false
+	if s.implicitTLS {
+		time.Sleep(s.tlsDelay)
+		tlsConn := tls.Server(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		conn, secure = tlsConn, true
+	}
+	r := bufio.NewReader(conn)
+	var pending []string
+	var chunks []byte
+	holding := false
+	write := func(reply string) {
+		if holding {
+			pending = append(pending, reply)
+			return
+		}
+		_, _ = fmt.Fprintf(conn, "%s\r\n", strings.ReplaceAll(reply, "\n", "\r\n"))
+	}
+	readLine := func() (string, bool) {
+		if s.idleTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		line, err := r.ReadString('\n')
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+			write("421 idle timeout")
+		}
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+
+	time.Sleep(s.greetingDelay)
+	write("220 mock ESMTP ready")
+	for {
+		cmd, ok := readLine()
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		s.cmds = append(s.cmds, cmd)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+		if s.pipelined {
+			switch verb {
+			case "MAIL":
+				holding = true
+			case "DATA":
+				holding = false
+				for _, reply := range pending {
+					write(reply)
+				}
+				pending = nil
+			}
+		}
+
+		if s.reply != nil {
+			if reply := s.reply(cmd); reply != "" {
+				write(reply)
+				continue
+			}
+		}
+
+		switch verb {
+		case "EHLO", "HELO":
+			exts := s.extensions
+			if secure {
+				exts = s.tlsExtensions
+				if exts == nil {
+					exts = nil
+					for _, ext := range s.extensions {
+						if ext != "STARTTLS" {
+							exts = append(exts, ext)
+						}
+					}
+				}
+			}
+			lines := append([]string{"mock"}, exts...)
+			for i, line := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				if i > 0 {
+					time.Sleep(s.lineDelay)
+				}
+				write("250" + sep + line)
+			}
+		case "STARTTLS":
+			write("220 ready to start TLS")
+			time.Sleep(s.tlsDelay)
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.upgrades++
+			s.mu.Unlock()
+			conn, secure = tlsConn, true
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			time.Sleep(s.authDelay)
+			if strings.HasPrefix(strings.ToUpper(cmd), "AUTH LOGIN") {
+				write("334 VXNlcm5hbWU6")
+				if _, ok := readLine(); !ok {
+					return
+				}
+				write("334 UGFzc3dvcmQ6")
+				if _, ok := readLine(); !ok {
+					return
+				}
+			}
+			write("235 authenticated")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			write("250 ok")
+		case "DATA":
+			write("354 go ahead")
+			var data strings.Builder
+			for {
+				line, ok := readLine()
+				if !ok {
+					return
+				}
+				if line == "." {
+					break
+				}
+				data.WriteString(line + "\r\n")
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply := ""
+			if s.reply != nil {
+				reply = s.reply(".")
+			}
+			if reply == "" {
+				reply = "250 queued"
+			}
+			write(reply)
+		case "BDAT":
+			// BDAT <size> [LAST]，内容按原样读取
+			fields := strings.Fields(cmd)
+			size, err := strconv.Atoi(fields[min(1, len(fields)-1)])
+			if err != nil {
+				write("501 invalid chunk size")
+				continue
+			}
+			chunk := make([]byte, size)
+			if _, err = io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			chunks = append(chunks, chunk...)
+			if len(fields) < 3 || !strings.EqualFold(fields[2], "LAST") {
+				write("250 chunk received")
+				continue
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(chunks))
+			s.mu.Unlock()
+			chunks = nil
+			reply := ""
+			if s.reply != nil {
+				reply = s.reply(".")
+			}
+			if reply == "" {
+				reply = "250 queued"
+			}
+			write(reply)
+		case "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("502 command not implemented")
+		}
+	}
+}
+
+// commands 返回服务器收到的全部命令
+func (s *mockServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+// received 返回服务器收到的全部邮件内容
+func (s *mockServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// connections 返回服务器接受的连接数
+func (s *mockServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// tlsUpgrades 返回成功完成STARTTLS的次数
+func (s *mockServer) tlsUpgrades() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upgrades
+}
+
+// verbs 返回命令的动词序列，便于断言命令顺序
+func verbs(cmds []string) []string {
+	var out []string
+	for _, cmd := range cmds {
+		out = append(out, strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]))
+	}
+	return out
+}
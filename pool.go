@@ -0,0 +1,294 @@
+package email
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxIdle 连接池中连接默认的最长空闲时间
+	defaultMaxIdle = 30 * time.Second
+	// livenessTimeout 取出空闲连接时NOOP检查的超时时间
+	livenessTimeout = 2 * time.Second
+	// quitTimeout 结束会话时等待QUIT响应的时间
+	quitTimeout = time.Second
+)
+
+// session 已认证的SMTP会话，开启Pool时在多次发送之间复用
+type session struct {
+	client    *smtp.Client
+	conn      net.Conn
+	quit      string // 结束会话的方式，见QuitWait等常量
+	idleSince time.Time
+	used      bool // 会话上是否已经进行过投递，用于SendResult.Reused
+}
+
+// begin 开始一次投递，返回会话此前是否已被使用过
+func (pc *session) begin() bool {
+	used := pc.used
+	pc.used = true
+	return used
+}
+
+// close 按quit结束会话并关闭连接，会话截止时间可能已过，为QUIT留出时间
+// 只有QuitWait返回QUIT的错误
+func (pc *session) close() error {
+	if pc.quit == QuitSkip {
+		return pc.client.Close()
+	}
+	_ = pc.conn.SetDeadline(time.Now().Add(quitTimeout))
+	err := pc.client.Quit()
+	_ = pc.client.Close()
+	if pc.quit == QuitIgnoreError {
+		return nil
+	}
+	return err
+}
+
+// connPool 按配置缓存已认证的空闲连接，供后续发送复用
+type connPool struct {
+	maxIdle   time.Duration
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	idle   map[*ConfigMapper][]*session
+	closed bool
+	stop   chan struct{}
+}
+
+func newConnPool(maxIdle, keepAlive time.Duration) *connPool {
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdle
+	}
+	p := &connPool{
+		maxIdle:   maxIdle,
+		keepAlive: keepAlive,
+		idle:      make(map[*ConfigMapper][]*session),
+		stop:      make(chan struct{}),
+	}
+	go p.reap()
+	if keepAlive > 0 {
+		go p.ping()
+	}
+	return p
+}
+
+// get 取出一个可用的空闲连接，取出前发送NOOP确认连接仍然有效，失效的连接被关闭并丢弃
+// 没有可用连接时返回nil
+func (p *connPool) get(ctx context.Context, config *ConfigMapper) *session {
+	for {
+		p.mu.Lock()
+		conns := p.idle[config]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		pc := conns[len(conns)-1]
+		p.idle[config] = conns[:len(conns)-1]
+		p.mu.Unlock()
+
+		_ = pc.conn.SetDeadline(time.Now().Add(livenessTimeout))
+		if err := pc.client.Noop(); err != nil {
+			_ = pc.client.Close()
+			continue
+		}
+		// 重新计算本次会话的截止时间
+		_ = pc.conn.SetDeadline(sessionDeadline(ctx, config))
+		return pc
+	}
+}
+
+// put 归还连接，连接池已关闭时直接结束会话
+func (p *connPool) put(config *ConfigMapper, pc *session) {
+	p.mu.Lock()
+	if !p.closed {
+		pc.idleSince = time.Now()
+		p.idle[config] = append(p.idle[config], pc)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	pc.close()
+}
+
+// reap 定期关闭空闲时间超过maxIdle的连接，直到连接池关闭；检查间隔至少为1毫秒
+func (p *connPool) reap() {
+	ticker := time.NewTicker(max(p.maxIdle/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			var expired []*session
+			p.mu.Lock()
+			for config, conns := range p.idle {
+				kept := conns[:0]
+				for _, pc := range conns {
+					if now.Sub(pc.idleSince) >= p.maxIdle {
+						expired = append(expired, pc)
+					} else {
+						kept = append(kept, pc)
+					}
+				}
+				p.idle[config] = kept
+			}
+			p.mu.Unlock()
+			for _, pc := range expired {
+				pc.close()
+			}
+		}
+	}
+}
+
+// ping 每隔keepAlive向所有空闲连接发送NOOP，防止服务器关闭长时间空闲的连接，直到连接池关闭
+// 检查期间连接暂时从连接池取出，NOOP失败的连接被关闭并丢弃；保持活跃不重置空闲时间，连接仍按maxIdle清理
+func (p *connPool) ping() {
+	ticker := time.NewTicker(p.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.closed {
+				p.mu.Unlock()
+				return
+			}
+			idle := p.idle
+			p.idle = make(map[*ConfigMapper][]*session, len(idle))
+			p.mu.Unlock()
+			for config, conns := range idle {
+				for _, pc := range conns {
+					_ = pc.conn.SetDeadline(time.Now().Add(livenessTimeout))
+					if err := pc.client.Noop(); err != nil {
+						_ = pc.client.Close()
+						continue
+					}
+					p.restore(config, pc)
+				}
+			}
+		}
+	}
+}
+
+// restore 将检查过的连接放回连接池，保留原来的空闲时间；连接池已关闭时直接结束会话
+func (p *connPool) restore(config *ConfigMapper, pc *session) {
+	p.mu.Lock()
+	if !p.closed {
+		p.idle[config] = append(p.idle[config], pc)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	pc.close()
+}
+
+// close 关闭所有空闲连接并停止清理
+func (p *connPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.stop)
+	p.mu.Unlock()
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.close()
+		}
+	}
+}
+
+// idleCount 返回连接池中的空闲连接数
+func (p *connPool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, conns := range p.idle {
+		n += len(conns)
+	}
+	return n
+}
+
+// openSession 建立连接，失败时按Retry.ConnectionAttempts重试
+// 使用完毕后调用返回的release，开启Pool时连接被归还到连接池，否则结束会话并返回QUIT的错误；
+// 调用方也可以直接结束会话而不归还到连接池
+func (m *Email) openSession(ctx context.Context, config *ConfigMapper) (*session, func() error, error) {
+	attempts := max(m.Retry.ConnectionAttempts, 1)
+	authRetried := false
+	for attempt := 1; ; attempt++ {
+		sess, release, err := m.openSessionOnce(ctx, config)
+		if m.Retry.AuthRetry && !authRetried && permanentAuthFailure(err) && ctx.Err() == nil {
+			// dial失败时已关闭连接，重新建立连接后再认证一次
+			authRetried = true
+			attempt--
+			if m.Logf != nil {
+				m.Logf("email: %v; retrying on a fresh connection to %s", err, config.Host)
+			}
+			continue
+		}
+		if err == nil || attempt >= attempts || !retryable(err) || !m.Retry.wait(ctx) {
+			return sess, release, err
+		}
+	}
+}
+
+// openSessionOnce 建立连接，开启Pool时优先复用连接池中的空闲连接
+func (m *Email) openSessionOnce(ctx context.Context, config *ConfigMapper) (*session, func() error, error) {
+	if m.Pool {
+		p := m.connPool()
+		if sess := p.get(ctx, config); sess != nil {
+			return sess, func() error { p.put(config, sess); return nil }, nil
+		}
+	}
+	m.warnInsecure(config)
+	metrics := ConnectMetrics{Addr: net.JoinHostPort(config.Host, strconv.Itoa(config.Port))}
+	start := time.Now()
+	smtpClient, conn, err := dial(ctx, config, &metrics)
+	if m.OnConnect != nil {
+		metrics.Total, metrics.Err = time.Since(start), err
+		m.OnConnect(metrics)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sess := &session{client: smtpClient, conn: conn, quit: m.Quit}
+	if m.Pool {
+		p := m.connPool()
+		return sess, func() error { p.put(config, sess); return nil }, nil
+	}
+	return sess, sess.close, nil
+}
+
+// connPool 返回Email的连接池，首次使用时创建
+func (m *Email) connPool() *connPool {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+	if m.pool == nil {
+		m.pool = newConnPool(m.MaxIdle, m.KeepAlive)
+	}
+	return m.pool
+}
+
+// Close 停止发送队列的后台任务并关闭连接池中的所有空闲连接，开启Pool或使用Enqueue的Email不再使用时应调用Close
+// Close之后仍可继续发送，将重新创建连接池；再次调用Enqueue时重新启动后台任务
+func (m *Email) Close() error {
+	m.stopQueue()
+	m.poolMu.Lock()
+	p := m.pool
+	m.pool = nil
+	m.poolMu.Unlock()
+	if p != nil {
+		p.close()
+	}
+	return nil
+}
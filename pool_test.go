@@ -0,0 +1,181 @@
+package email
+
+import (
+	"net/mail"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestEmail_PoolReuse tests that pooled connections are reused across sends
+func TestEmail_PoolReuse(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	defer email.Close()
+
+	for i := 0; i < 3; i++ {
+		if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+			t.Fatalf("send %d: unexpected errors: %v", i, errs)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected pooled connection to be reused, got %d connections", got)
+	}
+}
+
+// TestEmail_PoolReconnectAfterIdleTimeout tests reconnecting transparently after the server closed an idle connection
+func TestEmail_PoolReconnectAfterIdleTimeout(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, idleTimeout: 100 * time.Millisecond}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	defer email.Close()
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	// 等待服务器因空闲超时关闭连接
+	time.Sleep(300 * time.Millisecond)
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("expected transparent reconnect, got %v", errs)
+	}
+	if got := server.connections(); got != 2 {
+		t.Errorf("expected stale connection to be replaced, got %d connections", got)
+	}
+	if got := len(server.received()); got != 2 {
+		t.Errorf("expected 2 messages, got %d", got)
+	}
+}
+
+// TestEmail_PoolReapsIdleConnections tests closing connections idle longer than MaxIdle
+func TestEmail_PoolReapsIdleConnections(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	email.MaxIdle = 100 * time.Millisecond
+	defer email.Close()
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got := email.connPool().idleCount(); got != 1 {
+		t.Fatalf("expected 1 idle connection, got %d", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for email.connPool().idleCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := email.connPool().idleCount(); got != 0 {
+		t.Errorf("expected idle connection to be reaped, got %d", got)
+	}
+}
+
+// TestEmail_PoolTinyMaxIdle tests that a MaxIdle below two nanoseconds does not panic the reaper
+func TestEmail_PoolTinyMaxIdle(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	email.MaxIdle = 1
+	defer email.Close()
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for email.connPool().idleCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := email.connPool().idleCount(); got != 0 {
+		t.Errorf("expected idle connection to be reaped, got %d", got)
+	}
+}
+
+// TestEmail_PoolKeepAlive tests sending NOOP on idle pooled connections
+func TestEmail_PoolKeepAlive(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	email.MaxIdle = time.Minute
+	email.KeepAlive = 50 * time.Millisecond
+	defer email.Close()
+
+	noops := func() int {
+		n := 0
+		for _, verb := range verbs(server.commands()) {
+			if verb == "NOOP" {
+				n++
+			}
+		}
+		return n
+	}
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	time.Sleep(275 * time.Millisecond)
+	if got := noops(); got < 3 || got > 6 {
+		t.Errorf("expected about 5 keep-alive NOOPs, got %d", got)
+	}
+	if got := email.connPool().idleCount(); got != 1 {
+		t.Errorf("expected the connection to stay pooled, got %d", got)
+	}
+
+	// Close之后不再发送NOOP
+	if err := email.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sent := noops()
+	time.Sleep(200 * time.Millisecond)
+	if got := noops(); got != sent {
+		t.Errorf("expected keep-alive to stop after Close, got %d NOOPs then %d", sent, got)
+	}
+	if !slices.Contains(verbs(server.commands()), "QUIT") {
+		t.Errorf("expected the pooled session to be closed, got %v", server.commands())
+	}
+}
+
+// TestEmail_PoolKeepAliveEvictsBrokenConnections tests dropping pooled connections whose NOOP fails
+func TestEmail_PoolKeepAliveEvictsBrokenConnections(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, reply: func(cmd string) string {
+		if cmd == "NOOP" {
+			return "421 closing idle connection"
+		}
+		return ""
+	}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	email.MaxIdle = time.Minute
+	email.KeepAlive = 50 * time.Millisecond
+	defer email.Close()
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for email.connPool().idleCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := email.connPool().idleCount(); got != 0 {
+		t.Errorf("expected the broken connection to be evicted, got %d", got)
+	}
+}
+
+// TestEmail_PoolResultReused tests that SendResult.Reused reports pooled connections
+func TestEmail_PoolResultReused(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Pool = true
+	defer email.Close()
+
+	for i, want := range []bool{false, true, true} {
+		results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("send %d: unexpected error: %v", i, results[0].Err)
+		}
+		if results[0].Reused != want {
+			t.Errorf("send %d: expected Reused=%v, got %v", i, want, results[0].Reused)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected one connection, got %d", got)
+	}
+}
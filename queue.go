@@ -0,0 +1,224 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultQueueRetryDelay 队列中的邮件投递失败后默认的重试等待时间
+const defaultQueueRetryDelay = time.Minute
+
+// QueuedMessage 发送队列中等待投递的邮件
+type QueuedMessage struct {
+	// ID 队列中唯一的标识，由Enqueue生成
+	ID      string
+	Message *Message
+	// Attempts 已失败的投递次数
+	Attempts int
+	// NotBefore 最早的下次投递时间，零值表示立即投递；投递失败后Nack前设置为QueueRetryDelay之后
+	NotBefore time.Time
+}
+
+// Queue 发送队列，实现者可以将邮件持久化（如数据库或文件），程序重启后继续投递
+// Dequeue取出的邮件在Ack之前不应删除，Nack后应能再次被Dequeue取出，以保证至少一次投递
+type Queue interface {
+	// Enqueue 将邮件加入队列
+	Enqueue(msg *QueuedMessage) error
+	// Dequeue 取出下一封待投递的邮件，跳过NotBefore未到的邮件；没有可投递的邮件时阻塞直到有邮件或ctx结束
+	Dequeue(ctx context.Context) (*QueuedMessage, error)
+	// Ack 邮件投递成功，从队列中删除
+	Ack(id string) error
+	// Nack 邮件投递失败，放回队列等待重试，err为本次投递的错误；
+	// 调用前Dequeue返回的QueuedMessage的NotBefore已更新为下次投递时间，实现者应一并保存
+	Nack(id string, err error) error
+}
+
+// memoryQueue 内存中的发送队列，程序退出后队列中的邮件会丢失
+type memoryQueue struct {
+	mu       sync.Mutex
+	pending  []*QueuedMessage
+	inflight map[string]*QueuedMessage
+	ready    chan struct{}
+}
+
+// NewMemoryQueue 创建内存中的发送队列，Email.Queue为nil时使用
+func NewMemoryQueue() Queue {
+	return &memoryQueue{
+		inflight: make(map[string]*QueuedMessage),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+func (q *memoryQueue) Enqueue(msg *QueuedMessage) error {
+	q.mu.Lock()
+	q.pending = append(q.pending, msg)
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+func (q *memoryQueue) Dequeue(ctx context.Context) (*QueuedMessage, error) {
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var next time.Time
+		for i, msg := range q.pending {
+			if !msg.NotBefore.After(now) {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				q.inflight[msg.ID] = msg
+				q.mu.Unlock()
+				return msg, nil
+			}
+			if next.IsZero() || msg.NotBefore.Before(next) {
+				next = msg.NotBefore
+			}
+		}
+		q.mu.Unlock()
+
+		// 只有未到投递时间的邮件时等待最早的一封到期
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.ready:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (q *memoryQueue) Ack(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.inflight[id]; !ok {
+		return errors.New("email: unknown queued message " + id)
+	}
+	delete(q.inflight, id)
+	return nil
+}
+
+func (q *memoryQueue) Nack(id string, err error) error {
+	q.mu.Lock()
+	msg, ok := q.inflight[id]
+	if !ok {
+		q.mu.Unlock()
+		return errors.New("email: unknown queued message " + id)
+	}
+	delete(q.inflight, id)
+	msg.Attempts++
+	q.pending = append(q.pending, msg)
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+// signal 通知等待中的Dequeue有新邮件
+func (q *memoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue 将邮件加入发送队列，由后台任务通过BatchSend投递，首次调用时启动后台任务
+// 可重试的失败（见SendResult.Retryable）时邮件被Nack并在QueueRetryDelay后重试，等待期间不影响其他邮件的投递；
+// 5xx永久性错误、配置错误和邮件构建错误不再重试；
+// 调用Close停止后台任务，未投递的邮件保留在队列中
+func (m *Email) Enqueue(msg *Message) error {
+	q := m.startQueue()
+	random := m.random()
+	if random == nil {
+		random = rand.Reader
+	}
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(random, id); err != nil {
+		return err
+	}
+	return q.Enqueue(&QueuedMessage{ID: hex.EncodeToString(id), Message: msg})
+}
+
+// startQueue 启动发送队列的后台任务，返回使用的队列
+func (m *Email) startQueue() Queue {
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	if m.Queue == nil {
+		m.Queue = NewMemoryQueue()
+	}
+	if m.queueCancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.queueCancel = cancel
+		m.queueDone = make(chan struct{})
+		go m.runQueue(ctx, m.Queue, m.queueDone)
+	}
+	return m.Queue
+}
+
+// stopQueue 停止后台任务并等待正在进行的投递结束
+func (m *Email) stopQueue() {
+	m.queueMu.Lock()
+	cancel, done := m.queueCancel, m.queueDone
+	m.queueCancel, m.queueDone = nil, nil
+	m.queueMu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// runQueue 依次取出队列中的邮件投递，直到ctx结束
+func (m *Email) runQueue(ctx context.Context, q Queue, done chan struct{}) {
+	defer close(done)
+	delay := m.QueueRetryDelay
+	if delay <= 0 {
+		delay = defaultQueueRetryDelay
+	}
+	backoff := RetryPolicy{Backoff: delay}
+	for {
+		qm, err := q.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if m.Logf != nil {
+				m.Logf("dequeue failed: %v", err)
+			}
+			if !backoff.wait(ctx) {
+				return
+			}
+			continue
+		}
+
+		result := m.BatchSend(ctx, []*Message{qm.Message})[0]
+		switch {
+		case result.Err == nil:
+			err = q.Ack(qm.ID)
+		case !result.Retryable():
+			if m.Logf != nil {
+				m.Logf("queued message %s dropped: %v", qm.ID, result.Err)
+			}
+			err = q.Ack(qm.ID)
+		default:
+			qm.NotBefore = time.Now().Add(delay)
+			err = q.Nack(qm.ID, result.Err)
+		}
+		if err != nil {
+			if m.Logf != nil {
+				m.Logf("queued message %s: %v", qm.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingQueue 记录Ack和Nack调用的队列
+type recordingQueue struct {
+	Queue
+	mu     sync.Mutex
+	events []string
+	acked  chan struct{}
+}
+
+func (q *recordingQueue) record(event string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, event)
+}
+
+func (q *recordingQueue) Ack(id string) error {
+	q.record("ack")
+	defer close(q.acked)
+	return q.Queue.Ack(id)
+}
+
+func (q *recordingQueue) Nack(id string, err error) error {
+	q.record("nack")
+	return q.Queue.Nack(id, err)
+}
+
+// TestEmail_QueueRetry tests redelivering a queued message after a temporary failure
+func TestEmail_QueueRetry(t *testing.T) {
+	var mu sync.Mutex
+	failures := 1
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.HasPrefix(cmd, "RCPT TO:") && failures > 0 {
+				failures--
+				return "451 4.3.0 try again later"
+			}
+			return ""
+		},
+	}).start(t)
+
+	queue := &recordingQueue{Queue: NewMemoryQueue(), acked: make(chan struct{})}
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Queue = queue
+	email.QueueRetryDelay = 10 * time.Millisecond
+	defer email.Close()
+
+	err := email.Enqueue(&Message{To: []mail.Address{{Address: "user@example.com"}}, Subject: "测试主题", Body: "测试内容"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-queue.acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued message was not acked")
+	}
+
+	queue.mu.Lock()
+	events := strings.Join(queue.events, ",")
+	queue.mu.Unlock()
+	if events != "nack,ack" {
+		t.Errorf("expected nack then ack, got %s", events)
+	}
+	if received := server.received(); len(received) != 1 {
+		t.Errorf("expected 1 message after retry, got %d", len(received))
+	}
+}
+
+// TestEmail_QueueDropsPermanentFailures tests that local and permanent failures are acked without retrying
+func TestEmail_QueueDropsPermanentFailures(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	queue := &recordingQueue{Queue: NewMemoryQueue(), acked: make(chan struct{})}
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Queue = queue
+	email.MaxRecipientsPerSend = 1
+	defer email.Close()
+
+	err := email.Enqueue(&Message{To: []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}}, Subject: "主题", Body: "内容"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-queue.acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued message was not acked")
+	}
+	queue.mu.Lock()
+	events := strings.Join(queue.events, ",")
+	queue.mu.Unlock()
+	if events != "ack" {
+		t.Errorf("expected the message to be dropped without retrying, got %s", events)
+	}
+	if len(server.received()) != 0 {
+		t.Error("expected no message to be sent")
+	}
+}
+
+// TestMemoryQueue_Dequeue tests blocking Dequeue and redelivery after Nack
+func TestMemoryQueue_Dequeue(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded on empty queue, got %v", err)
+	}
+
+	_ = q.Enqueue(&QueuedMessage{ID: "1"})
+	msg, err := q.Dequeue(context.Background())
+	if err != nil || msg.ID != "1" {
+		t.Fatalf("unexpected dequeue result: %v %v", msg, err)
+	}
+	if err := q.Nack("1", nil); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ = q.Dequeue(context.Background())
+	if msg.Attempts != 1 {
+		t.Errorf("expected 1 failed attempt, got %d", msg.Attempts)
+	}
+	if err := q.Ack("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack("1"); err == nil {
+		t.Error("expected error acking an unknown message")
+	}
+
+	// NotBefore未到的邮件不阻塞之后加入的邮件，到期后再被取出
+	_ = q.Enqueue(&QueuedMessage{ID: "2", NotBefore: time.Now().Add(100 * time.Millisecond)})
+	_ = q.Enqueue(&QueuedMessage{ID: "3"})
+	if msg, _ = q.Dequeue(context.Background()); msg.ID != "3" {
+		t.Errorf("expected the due message first, got %s", msg.ID)
+	}
+	start := time.Now()
+	if msg, _ = q.Dequeue(context.Background()); msg.ID != "2" {
+		t.Errorf("expected the delayed message, got %s", msg.ID)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the delayed message to wait for NotBefore, got it after %v", elapsed)
+	}
+}
@@ -0,0 +1,41 @@
+package email
+
+import (
+	"html"
+	"strings"
+)
+
+// QuoteReply 生成引用原邮件的回复正文：回复内容在前，之后为引用说明（如"张三 于 2025-12-16 写道："）和原邮件
+// isHTML为false时原邮件的每一行以">"开头，已引用的行再增加一层">"；
+// isHTML为true时原邮件放在blockquote中，reply和original应为HTML片段，attribution按纯文本转义
+func QuoteReply(reply, original, attribution string, isHTML bool) string {
+	if isHTML {
+		var b strings.Builder
+		b.WriteString(reply)
+		b.WriteString("\n<div>")
+		b.WriteString(html.EscapeString(attribution))
+		b.WriteString("</div>\n<blockquote type=\"cite\" style=\"margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex\">\n")
+		b.WriteString(original)
+		b.WriteString("\n</blockquote>\n")
+		return b.String()
+	}
+
+	original = strings.TrimRight(strings.ReplaceAll(original, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(reply, "\n"))
+	b.WriteString("\n\n")
+	b.WriteString(attribution)
+	b.WriteString("\n")
+	for _, line := range strings.Split(original, "\n") {
+		switch {
+		case line == "":
+			b.WriteString(">")
+		case strings.HasPrefix(line, ">"):
+			b.WriteString(">" + line)
+		default:
+			b.WriteString("> " + line)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestQuoteReply_Text tests quoting a plain text original
+func TestQuoteReply_Text(t *testing.T) {
+	original := "您好，\r\n\r\n> 上一封邮件\r\n订单无法支付。\r\n"
+	got := QuoteReply("问题已处理。", original, "张三 于 2025-12-16 写道：", false)
+	want := "问题已处理。\n\n张三 于 2025-12-16 写道：\n> 您好，\n>\n>> 上一封邮件\n> 订单无法支付。\n"
+	if got != want {
+		t.Errorf("unexpected quoted reply:\n got %q\nwant %q", got, want)
+	}
+}
+
+// TestQuoteReply_HTML tests quoting an HTML original in a blockquote
+func TestQuoteReply_HTML(t *testing.T) {
+	got := QuoteReply("<p>问题已处理。</p>", "<p>订单无法支付。</p>", "张三 <zhangsan@example.com> 写道：", true)
+	reply := strings.Index(got, "<p>问题已处理。</p>")
+	attribution := strings.Index(got, "<div>张三 &lt;zhangsan@example.com&gt; 写道：</div>")
+	quoted := strings.Index(got, "<p>订单无法支付。</p>")
+	if reply != 0 || attribution < reply || quoted < attribution {
+		t.Fatalf("expected reply, attribution and original in order, got:\n%s", got)
+	}
+	if !strings.Contains(got[attribution:quoted], "<blockquote") || !strings.Contains(got[quoted:], "</blockquote>") {
+		t.Errorf("expected original wrapped in blockquote, got:\n%s", got)
+	}
+}
@@ -0,0 +1,151 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// 投递状态通知中收件人的Action字段（RFC 3464 2.3.3节）
+const (
+	ActionFailed    = "failed"
+	ActionDelayed   = "delayed"
+	ActionDelivered = "delivered"
+	ActionRelayed   = "relayed"
+	ActionExpanded  = "expanded"
+)
+
+// RecipientStatus 投递状态通知中一个收件人的投递状态
+type RecipientStatus struct {
+	Recipient      string // 收件人地址，写入Final-Recipient字段
+	Action         string // 投递结果，见ActionFailed等常量
+	Status         string // 状态码，如"5.1.1"
+	RemoteMTA      string // 可选，返回该状态的服务器，如"mx.example.com"
+	DiagnosticCode string // 可选，服务器的原始响应，如"550 5.1.1 user unknown"
+}
+
+// DeliveryReport 投递状态通知（DSN，RFC 3464），设置在Message.Report上时邮件按multipart/report格式序列化：
+// 依次为正文（供人阅读的说明）、message/delivery-status部分和原邮件头（text/rfc822-headers）
+type DeliveryReport struct {
+	ReportingMTA   string    // 生成通知的服务器，如"relay.example.com"
+	ArrivalDate    time.Time // 可选，原邮件到达的时间
+	Recipients     []RecipientStatus
+	OriginalHeader []byte // 原邮件的头；传入完整邮件时只使用空行之前的部分
+}
+
+// NewDeliveryReport 为原邮件生成投递状态通知，正文列出每个收件人的投递结果
+// 通知发往原邮件的发件人to；按RFC 5321退信的信封发件人应为空，由调用方的中继配置保证
+func NewDeliveryReport(from, to mail.Address, report *DeliveryReport) *Message {
+	failed := false
+	var text strings.Builder
+	text.WriteString("This is an automatically generated Delivery Status Notification.\r\n\r\n")
+	for _, recipient := range report.Recipients {
+		failed = failed || recipient.Action == ActionFailed
+		fmt.Fprintf(&text, "%s: %s (%s)", recipient.Recipient, recipient.Action, recipient.Status)
+		if recipient.DiagnosticCode != "" {
+			fmt.Fprintf(&text, "\r\n    %s", recipient.DiagnosticCode)
+		}
+		text.WriteString("\r\n")
+	}
+	subject := "Delivery Status Notification"
+	if failed {
+		subject += " (Failure)"
+	}
+	return &Message{
+		From:    from,
+		To:      []mail.Address{to},
+		Subject: subject,
+		Body:    text.String(),
+		Report:  report,
+		Header:  textproto.MIMEHeader{"Auto-Submitted": {AutoReplied}},
+	}
+}
+
+// deliveryStatus 生成message/delivery-status部分的内容
+func (r *DeliveryReport) deliveryStatus() ([]byte, error) {
+	if r.ReportingMTA == "" {
+		return nil, errors.New("email: delivery report requires a reporting MTA")
+	}
+	if len(r.Recipients) == 0 {
+		return nil, errors.New("email: delivery report requires at least one recipient")
+	}
+	var buf bytes.Buffer
+	field := func(name, value string) error {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("email: invalid value for delivery status field %s", name)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		return nil
+	}
+	if err := field("Reporting-MTA", "dns; "+r.ReportingMTA); err != nil {
+		return nil, err
+	}
+	if !r.ArrivalDate.IsZero() {
+		_ = field("Arrival-Date", r.ArrivalDate.Format(time.RFC1123Z))
+	}
+	for _, recipient := range r.Recipients {
+		if recipient.Recipient == "" || recipient.Action == "" || recipient.Status == "" {
+			return nil, errors.New("email: delivery report recipient requires an address, action and status")
+		}
+		buf.WriteString("\r\n")
+		fields := [][2]string{
+			{"Final-Recipient", "rfc822; " + recipient.Recipient},
+			{"Action", recipient.Action},
+			{"Status", recipient.Status},
+		}
+		if recipient.RemoteMTA != "" {
+			fields = append(fields, [2]string{"Remote-MTA", "dns; " + recipient.RemoteMTA})
+		}
+		if recipient.DiagnosticCode != "" {
+			fields = append(fields, [2]string{"Diagnostic-Code", "smtp; " + recipient.DiagnosticCode})
+		}
+		for _, f := range fields {
+			if err := field(f[0], f[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// originalHeader 返回原邮件头，换行统一为CRLF并以CRLF结尾
+func (r *DeliveryReport) originalHeader() []byte {
+	header := bytes.ReplaceAll(r.OriginalHeader, []byte("\r\n"), []byte("\n"))
+	if end := bytes.Index(header, []byte("\n\n")); end >= 0 {
+		header = header[:end+1]
+	}
+	header = bytes.ReplaceAll(header, []byte("\n"), []byte("\r\n"))
+	if len(header) > 0 && !bytes.HasSuffix(header, []byte("\r\n")) {
+		header = append(header, "\r\n"...)
+	}
+	return header
+}
+
+// reportPart 生成multipart/report内容，正文作为第一个部分
+func reportPart(bodyHeader textproto.MIMEHeader, body []byte, report *DeliveryReport, random io.Reader) (textproto.MIMEHeader, []byte, error) {
+	status, err := report.deliveryStatus()
+	if err != nil {
+		return nil, nil, err
+	}
+	boundary, content, err := writeMultipart([]MIMEPart{
+		{Header: bodyHeader, Body: body},
+		{Header: textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}}, Body: status},
+		{Header: textproto.MIMEHeader{"Content-Type": {"text/rfc822-headers"}}, Body: report.originalHeader()},
+	}, random)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/report", map[string]string{
+			"report-type": "delivery-status",
+			"boundary":    boundary,
+		})},
+	}
+	return header, content, nil
+}
@@ -0,0 +1,88 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewDeliveryReport tests the multipart/report structure of a delivery status notification
+func TestNewDeliveryReport(t *testing.T) {
+	original := "From: sender@example.com\nTo: missing@example.org\nSubject: 周报\nMessage-ID: <1@example.com>\n\n正文不应出现在通知中\n"
+	msg := NewDeliveryReport(mail.Address{Name: "Mail Delivery System", Address: "mailer-daemon@relay.example.com"},
+		mail.Address{Address: "sender@example.com"}, &DeliveryReport{
+			ReportingMTA: "relay.example.com",
+			ArrivalDate:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			Recipients: []RecipientStatus{{
+				Recipient:      "missing@example.org",
+				Action:         ActionFailed,
+				Status:         "5.1.1",
+				RemoteMTA:      "mx.example.org",
+				DiagnosticCode: "550 5.1.1 user unknown",
+			}},
+			OriginalHeader: []byte(original),
+		})
+	if err := msg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	message, err := (&Email{}).prepare(msg, msg.To)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("failed to parse report: %v", err)
+	}
+	if got := parsed.Header.Get("Subject"); got != "Delivery Status Notification (Failure)" {
+		t.Errorf("unexpected subject %q", got)
+	}
+	if got := parsed.Header.Get("Auto-Submitted"); got != AutoReplied {
+		t.Errorf("expected Auto-Submitted: auto-replied, got %q", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("expected multipart/report; report-type=delivery-status, got %q", parsed.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		data, _ := io.ReadAll(part)
+		types = append(types, strings.SplitN(part.Header.Get("Content-Type"), ";", 2)[0])
+		bodies = append(bodies, string(data))
+	}
+	if want := []string{"text/plain", "message/delivery-status", "text/rfc822-headers"}; strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected parts %v, got %v", want, types)
+	}
+	if !strings.Contains(bodies[0], "missing@example.org: failed (5.1.1)") {
+		t.Errorf("expected a human-readable summary:\n%s", bodies[0])
+	}
+	for _, want := range []string{
+		"Reporting-MTA: dns; relay.example.com\r\nArrival-Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n\r\n",
+		"Final-Recipient: rfc822; missing@example.org\r\nAction: failed\r\nStatus: 5.1.1\r\n",
+		"Remote-MTA: dns; mx.example.org\r\nDiagnostic-Code: smtp; 550 5.1.1 user unknown\r\n",
+	} {
+		if !strings.Contains(bodies[1], want) {
+			t.Errorf("expected %q in delivery status:\n%s", want, bodies[1])
+		}
+	}
+	if !strings.Contains(bodies[2], "Message-ID: <1@example.com>\r\n") || strings.Contains(bodies[2], "正文") {
+		t.Errorf("expected only the original headers:\n%s", bodies[2])
+	}
+
+	msg.Attachments = []*Attachment{NewAttachment("a.txt", []byte("a"))}
+	if _, err = (&Email{}).prepare(msg, msg.To); err == nil {
+		t.Errorf("expected an error for a report with attachments")
+	}
+}
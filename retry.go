@@ -0,0 +1,77 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"time"
+)
+
+// RetryPolicy 发送失败时的重试策略，连接阶段和投递阶段分别设置最大尝试次数
+// 只有4xx临时错误和连接中断等网络错误会重试；5xx永久性错误（如550用户不存在）、证书验证失败等本地错误不会重试
+type RetryPolicy struct {
+	// ConnectionAttempts 建立连接、TLS握手和认证阶段的最大尝试次数，0或1表示不重试
+	ConnectionAttempts int
+	// TransactionAttempts 投递阶段（MAIL FROM、RCPT TO、DATA）的最大尝试次数，0或1表示不重试；
+	// 每次重试都会重新建立连接
+	TransactionAttempts int
+	// Backoff 两次尝试之间的等待时间
+	Backoff time.Duration
+	// AuthRetry 认证被服务器以5xx拒绝时关闭连接并重新建立一次连接再认证，用于服务器的临时状态导致认证失败的情况；
+	// 不计入ConnectionAttempts，只重试一次。默认关闭，避免掩盖真正的凭据错误
+	AuthRetry bool
+}
+
+// retryable 判断错误是否可以重试：只有服务器的4xx临时错误、网络错误和连接中断（EOF）可以重试，
+// 5xx永久性错误以及未加密、证书验证失败、配置错误等本地或永久性的错误不重试
+func retryable(err error) bool {
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// wait 在两次尝试之间等待Backoff，ctx被取消时返回false
+func (p RetryPolicy) wait(ctx context.Context) bool {
+	if p.Backoff <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(p.Backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Retryable 判断发送失败后是否值得稍后重试：服务器的4xx临时错误、连接中断等网络错误，
+// 以及BatchSend中未开始投递的邮件可以重试；5xx永久性错误、配置错误和邮件构建错误不可重试，
+// 邮件已被接受后QUIT失败（ErrQuitFailed）也不可重试
+func (r SendResult) Retryable() bool {
+	if r.Err == nil || errors.Is(r.Err, ErrQuitFailed) {
+		return false
+	}
+	return errors.Is(r.Err, ErrNotAttempted) || retryable(r.Err)
+}
+
+// RetryableRecipients 返回发送结果中可以重试的收件人，用于只重发临时失败的部分
+func RetryableRecipients(results []SendResult) []mail.Address {
+	var recipients []mail.Address
+	for _, result := range results {
+		if result.Retryable() {
+			recipients = append(recipients, result.Recipient)
+		}
+	}
+	return recipients
+}
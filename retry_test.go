@@ -0,0 +1,209 @@
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// rcptCount 返回服务器收到的RCPT命令数
+func rcptCount(s *mockServer) int {
+	n := 0
+	for _, verb := range verbs(s.commands()) {
+		if verb == "RCPT" {
+			n++
+		}
+	}
+	return n
+}
+
+// TestEmail_RetryNotOnPermanentRejection tests that a 5xx rejection is not retried
+func TestEmail_RetryNotOnPermanentRejection(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if strings.HasPrefix(cmd, "RCPT") {
+				return "550 user unknown"
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Retry = RetryPolicy{ConnectionAttempts: 3, TransactionAttempts: 3}
+
+	errs := email.Send("", []mail.Address{{Address: "missing@example.com"}}, "subject", "content")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "550") {
+		t.Fatalf("expected 550 error, got %v", errs)
+	}
+	if got := rcptCount(server); got != 1 {
+		t.Errorf("expected 550 not to be retried, got %d attempts", got)
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected 1 connection, got %d", got)
+	}
+}
+
+// TestEmail_RetryTemporaryFailure tests that a 4xx reply is retried within TransactionAttempts
+func TestEmail_RetryTemporaryFailure(t *testing.T) {
+	var rejected atomic.Bool
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if strings.HasPrefix(cmd, "RCPT") && rejected.CompareAndSwap(false, true) {
+				return "421 try again later"
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Retry = RetryPolicy{TransactionAttempts: 3}
+
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) > 0 {
+		t.Fatalf("expected 421 to be retried, got %v", errs)
+	}
+	if got := rcptCount(server); got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+	if got := len(server.received()); got != 1 {
+		t.Errorf("expected 1 message, got %d", got)
+	}
+}
+
+// TestEmail_RetryConnection tests that dropped connections are retried up to ConnectionAttempts
+func TestEmail_RetryConnection(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		drop        int
+		policy      RetryPolicy
+		connections int
+		fails       bool
+	}{
+		{"reset", 2, RetryPolicy{ConnectionAttempts: 3}, 3, false},
+		{"exhausted", 5, RetryPolicy{ConnectionAttempts: 2, TransactionAttempts: 5}, 2, true},
+	} {
+		server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, drop: tc.drop}).start(t)
+		email := New(map[string]*ConfigMapper{"default": server.config(false)})
+		email.Retry = tc.policy
+
+		if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); (len(errs) == 1) != tc.fails {
+			t.Fatalf("%s: unexpected errors: %v", tc.name, errs)
+		}
+		if got := server.connections(); got != tc.connections {
+			t.Errorf("%s: expected %d connection attempts, got %d", tc.name, tc.connections, got)
+		}
+	}
+}
+
+// TestEmail_RetrySkipsLocalErrors tests that local and permanent connection errors are not retried
+func TestEmail_RetrySkipsLocalErrors(t *testing.T) {
+	// 服务器只在加密连接上提供认证
+	server := (&mockServer{extensions: []string{"STARTTLS"}, tlsExtensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+	config := server.config(false)
+	email := New(map[string]*ConfigMapper{"default": config})
+	email.Retry = RetryPolicy{ConnectionAttempts: 3}
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) != 1 || !errors.Is(errs[0], ErrUnencrypted) {
+		t.Fatalf("expected ErrUnencrypted, got %v", errs)
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected a single connection attempt, got %d", got)
+	}
+
+	// 证书验证失败
+	server = (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}, implicitTLS: true}).start(t)
+	config = server.config(true)
+	config.SkipTLSVerify = false
+	email = New(map[string]*ConfigMapper{"default": config})
+	email.Retry = RetryPolicy{ConnectionAttempts: 3}
+	if errs := email.Send("", []mail.Address{{Address: "a@example.com"}}, "subject", "content"); len(errs) != 1 {
+		t.Fatalf("expected a certificate error, got %v", errs)
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("expected a single connection attempt, got %d", got)
+	}
+}
+
+// TestRetryableRecipients tests that only temporary and network failures are reported as retryable
+func TestRetryableRecipients(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			switch {
+			case strings.HasPrefix(cmd, "RCPT TO:<perm@"):
+				return "550 5.1.1 user unknown"
+			case strings.HasPrefix(cmd, "RCPT TO:<temp@"):
+				return "451 4.3.0 try again later"
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	results := email.SendWithOptions("", []mail.Address{
+		{Address: "perm@example.com"},
+		{Address: "temp@example.com"},
+		{Address: "ok@example.com"},
+	}, "subject", "content", SendOptions{})
+	retry := RetryableRecipients(results)
+	if len(retry) != 1 || retry[0].Address != "temp@example.com" {
+		t.Errorf("expected only temp@example.com to be retryable, got %v", retry)
+	}
+	if results[0].Retryable() || results[2].Retryable() {
+		t.Errorf("expected permanent failure and success not to be retryable: %+v", results)
+	}
+
+	// 连接被重置属于网络错误，可以重试
+	dropped := (&mockServer{drop: 1}).start(t)
+	email = New(map[string]*ConfigMapper{"default": dropped.config(false)})
+	results = email.SendWithOptions("", []mail.Address{{Address: "user@example.com"}}, "subject", "content", SendOptions{})
+	if !results[0].Retryable() {
+		t.Errorf("expected dropped connection to be retryable, got %v", results[0].Err)
+	}
+}
+
+// TestEmail_AuthRetry tests that AuthRetry re-dials once after an authentication failure
+func TestEmail_AuthRetry(t *testing.T) {
+	var auths atomic.Int32
+	var reject atomic.Bool
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			// 只有第一个连接上的认证失败
+			if strings.HasPrefix(cmd, "AUTH") && (auths.Add(1) == 1 || reject.Load()) {
+				return "535 5.7.8 authentication service temporarily unavailable"
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	// 默认不重试，5xx认证失败直接返回
+	results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "authentication failed: 535") {
+		t.Fatalf("expected the authentication failure, got %v", results[0].Err)
+	}
+	if got := server.connections(); got != 1 {
+		t.Fatalf("expected no retry by default, got %d connections", got)
+	}
+
+	auths.Store(0)
+	email.Retry.AuthRetry = true
+	results = email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("expected success after re-dialing, got %v", results[0].Err)
+	}
+	if got := server.connections(); got != 3 {
+		t.Errorf("expected one fresh connection for the auth retry, got %d connections in total", got)
+	}
+
+	// 只重试一次，凭据错误仍然返回
+	reject.Store(true)
+	results = email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	if results[0].Err == nil {
+		t.Errorf("expected persistent authentication failures to be reported")
+	}
+	if got := server.connections(); got != 5 {
+		t.Errorf("expected a single auth retry, got %d connections in total", got)
+	}
+}
@@ -0,0 +1,151 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// SMTPError 服务器返回的错误响应
+// 服务器声明ENHANCEDSTATUSCODES扩展（RFC 2034）时，Enhanced为响应中的增强状态码，如"5.1.1"
+type SMTPError struct {
+	Code     int    // 响应码，如550
+	Enhanced string // 增强状态码，服务器不支持时为空
+	Message  string // 响应文本，不包含增强状态码
+
+	err *textproto.Error
+}
+
+func (e *SMTPError) Error() string {
+	if e.Enhanced != "" {
+		return fmt.Sprintf("%03d %s %s", e.Code, e.Enhanced, e.Message)
+	}
+	return fmt.Sprintf("%03d %s", e.Code, e.Message)
+}
+
+// Unwrap 返回原始的textproto.Error
+func (e *SMTPError) Unwrap() error {
+	return e.err
+}
+
+// Temporary 判断是否为4xx临时错误
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// enhancedStatus 增强状态码的说明和对应的拒绝原因
+type enhancedStatus struct {
+	detail string
+	bounce BounceCategory
+}
+
+// enhancedStatuses 常见增强状态码（去掉类别）的说明和拒绝原因（RFC 3463、RFC 7505）
+// 未列出的security or policy状态码归为PolicyRejection
+var enhancedStatuses = map[string]enhancedStatus{
+	"1.1":  {"bad destination mailbox address", MailboxUnavailable},
+	"1.2":  {"bad destination system address", MailboxUnavailable},
+	"1.3":  {"bad destination mailbox address syntax", BounceUnknown},
+	"1.6":  {"destination mailbox has moved", MailboxUnavailable},
+	"1.8":  {"bad sender's system address", BounceUnknown},
+	"1.10": {"recipient address has null MX", MailboxUnavailable},
+	"2.1":  {"mailbox disabled", MailboxUnavailable},
+	"2.2":  {"mailbox full", MailboxFull},
+	"2.3":  {"message length exceeds administrative limit", BounceUnknown},
+	"3.4":  {"message too big for system", BounceUnknown},
+	"4.1":  {"no answer from host", BounceUnknown},
+	"4.4":  {"unable to route", BounceUnknown},
+	"4.5":  {"mail system congestion", RateLimited},
+	"4.7":  {"delivery time expired", BounceUnknown},
+	"5.3":  {"too many recipients", BounceUnknown},
+	"7.1":  {"delivery not authorized, message refused", PolicyRejection},
+	"7.8":  {"authentication credentials invalid", PolicyRejection},
+	"7.26": {"multiple authentication checks failed", PolicyRejection},
+	"7.28": {"mail flood detected", RateLimited},
+}
+
+// enhancedSubjects 增强状态码主题的说明（RFC 3463），用于没有具体说明的状态码
+var enhancedSubjects = map[string]string{
+	"0": "other or undefined status",
+	"1": "addressing status",
+	"2": "mailbox status",
+	"3": "mail system status",
+	"4": "network and routing status",
+	"5": "mail delivery protocol status",
+	"6": "message content or media status",
+	"7": "security or policy status",
+}
+
+// Category 返回增强状态码的可读分类，如5.2.2为"mailbox full"；没有增强状态码时返回空字符串
+func (e *SMTPError) Category() string {
+	if e.Enhanced == "" {
+		return ""
+	}
+	_, subjectDetail, _ := strings.Cut(e.Enhanced, ".")
+	if status, ok := enhancedStatuses[subjectDetail]; ok {
+		return status.detail
+	}
+	subject, _, _ := strings.Cut(subjectDetail, ".")
+	if category, ok := enhancedSubjects[subject]; ok {
+		return category
+	}
+	return "unknown status"
+}
+
+// BounceCategory 拒绝原因的分类，由增强状态码映射，用于按原因处理被拒绝的收件人
+type BounceCategory int
+
+// 拒绝原因的分类
+const (
+	BounceUnknown      BounceCategory = iota // 没有增强状态码或不属于以下分类
+	MailboxUnavailable                       // 邮箱不存在、已停用或已迁移，如5.1.1、5.2.1
+	MailboxFull                              // 邮箱已满，如4.2.2、5.2.2
+	PolicyRejection                          // 因安全或策略原因被拒绝，如5.7.1、5.7.26
+	RateLimited                              // 发送频率过高或服务器拥塞，如4.7.28、4.4.5
+)
+
+var bounceCategoryNames = [...]string{"unknown", "mailbox unavailable", "mailbox full", "policy rejection", "rate limited"}
+
+func (c BounceCategory) String() string {
+	if c < 0 || int(c) >= len(bounceCategoryNames) {
+		return "unknown"
+	}
+	return bounceCategoryNames[c]
+}
+
+// Bounce 返回增强状态码对应的拒绝原因，没有增强状态码时返回BounceUnknown
+func (e *SMTPError) Bounce() BounceCategory {
+	if e.Enhanced == "" {
+		return BounceUnknown
+	}
+	_, subjectDetail, _ := strings.Cut(e.Enhanced, ".")
+	if status, ok := enhancedStatuses[subjectDetail]; ok {
+		return status.bounce
+	}
+	if strings.HasPrefix(subjectDetail, "7.") {
+		return PolicyRejection
+	}
+	return BounceUnknown
+}
+
+// enhancedCode 匹配响应文本开头的增强状态码，类别必须为2、4或5
+var enhancedCode = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})\s+`)
+
+// wrapSMTPError 将服务器的错误响应转换为SMTPError，服务器声明ENHANCEDSTATUSCODES时解析增强状态码
+// 其他错误原样返回
+func wrapSMTPError(smtpClient *smtp.Client, err error) error {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return err
+	}
+	smtpErr := &SMTPError{Code: protoErr.Code, Message: protoErr.Msg, err: protoErr}
+	if ok, _ := smtpClient.Extension("ENHANCEDSTATUSCODES"); ok {
+		if match := enhancedCode.FindStringSubmatch(protoErr.Msg); match != nil {
+			smtpErr.Enhanced = match[1]
+			smtpErr.Message = protoErr.Msg[len(match[0]):]
+		}
+	}
+	return smtpErr
+}
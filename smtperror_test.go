@@ -0,0 +1,146 @@
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestEmail_EnhancedStatusCodes tests parsing enhanced status codes when the server advertises ENHANCEDSTATUSCODES
+func TestEmail_EnhancedStatusCodes(t *testing.T) {
+	replies := map[string]string{
+		"RCPT TO:<missing@example.com>": "550 5.1.1 user unknown",
+		"RCPT TO:<full@example.com>":    "452 4.2.2 mailbox full",
+		"RCPT TO:<odd@example.com>":     "550 5.9.9 something else",
+	}
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN", "ENHANCEDSTATUSCODES"},
+		reply:      func(cmd string) string { return replies[cmd] },
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	toList := []mail.Address{{Address: "missing@example.com"}, {Address: "full@example.com"}, {Address: "odd@example.com"}}
+	results := email.SendWithOptions("", toList, "subject", "content", SendOptions{})
+	want := []struct {
+		code      int
+		enhanced  string
+		category  string
+		temporary bool
+	}{
+		{550, "5.1.1", "bad destination mailbox address", false},
+		{452, "4.2.2", "mailbox full", true},
+		{550, "5.9.9", "unknown status", false},
+	}
+	for i, w := range want {
+		var smtpErr *SMTPError
+		if !errors.As(results[i].Err, &smtpErr) {
+			t.Fatalf("%s: expected SMTPError, got %v", toList[i].Address, results[i].Err)
+		}
+		if smtpErr.Code != w.code || smtpErr.Enhanced != w.enhanced || smtpErr.Category() != w.category || smtpErr.Temporary() != w.temporary {
+			t.Errorf("%s: unexpected error %+v (category %q)", toList[i].Address, smtpErr, smtpErr.Category())
+		}
+		if strings.Contains(smtpErr.Message, w.enhanced) {
+			t.Errorf("%s: expected enhanced code to be removed from message %q", toList[i].Address, smtpErr.Message)
+		}
+	}
+	if got := results[0].Err.Error(); got != "failed to set recipient: 550 5.1.1 user unknown" {
+		t.Errorf("unexpected error message %q", got)
+	}
+}
+
+// TestEmail_EnhancedStatusCodesNotAdvertised tests that enhanced status codes are not parsed without ENHANCEDSTATUSCODES
+func TestEmail_EnhancedStatusCodesNotAdvertised(t *testing.T) {
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN"},
+		reply: func(cmd string) string {
+			if strings.HasPrefix(cmd, "RCPT") {
+				return "550 5.1.1 user unknown"
+			}
+			return ""
+		},
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	results := email.SendWithOptions("", []mail.Address{{Address: "a@example.com"}}, "subject", "content", SendOptions{})
+	var smtpErr *SMTPError
+	if !errors.As(results[0].Err, &smtpErr) {
+		t.Fatalf("expected SMTPError, got %v", results[0].Err)
+	}
+	if smtpErr.Enhanced != "" || smtpErr.Category() != "" || smtpErr.Message != "5.1.1 user unknown" {
+		t.Errorf("expected enhanced code not to be parsed, got %+v", smtpErr)
+	}
+}
+
+// TestEmail_BounceCategory tests mapping rejections to bounce categories
+func TestEmail_BounceCategory(t *testing.T) {
+	replies := map[string]string{
+		"RCPT TO:<missing@example.com>":  "550 5.1.1 user unknown",
+		"RCPT TO:<disabled@example.com>": "550 5.2.1 mailbox disabled",
+		"RCPT TO:<full@example.com>":     "452 4.2.2 mailbox full",
+		"RCPT TO:<policy@example.com>":   "550 5.7.1 message refused",
+		"RCPT TO:<dmarc@example.com>":    "550 5.7.26 unauthenticated email is not accepted",
+		"RCPT TO:<burst@example.com>":    "421 4.7.28 rate limited",
+		"RCPT TO:<busy@example.com>":     "451 4.4.5 system congestion",
+		"RCPT TO:<other@example.com>":    "554 5.3.0 other mail system problem",
+	}
+	server := (&mockServer{
+		extensions: []string{"AUTH LOGIN PLAIN", "ENHANCEDSTATUSCODES"},
+		reply:      func(cmd string) string { return replies[cmd] },
+	}).start(t)
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+
+	want := map[string]BounceCategory{
+		"missing@example.com":  MailboxUnavailable,
+		"disabled@example.com": MailboxUnavailable,
+		"full@example.com":     MailboxFull,
+		"policy@example.com":   PolicyRejection,
+		"dmarc@example.com":    PolicyRejection,
+		"burst@example.com":    RateLimited,
+		"busy@example.com":     RateLimited,
+		"other@example.com":    BounceUnknown,
+	}
+	var toList []mail.Address
+	for address := range want {
+		toList = append(toList, mail.Address{Address: address})
+	}
+	for _, result := range email.SendWithOptions("", toList, "subject", "content", SendOptions{}) {
+		var smtpErr *SMTPError
+		if !errors.As(result.Err, &smtpErr) {
+			t.Fatalf("%s: expected SMTPError, got %v", result.Recipient.Address, result.Err)
+		}
+		if got := smtpErr.Bounce(); got != want[result.Recipient.Address] {
+			t.Errorf("%s: expected %v, got %v", result.Recipient.Address, want[result.Recipient.Address], got)
+		}
+	}
+	if got := (&SMTPError{Code: 550, Message: "user unknown"}).Bounce(); got != BounceUnknown {
+		t.Errorf("expected BounceUnknown without an enhanced code, got %v", got)
+	}
+	if MailboxFull.String() != "mailbox full" {
+		t.Errorf("unexpected name %q", MailboxFull.String())
+	}
+}
+
+// TestSMTPError_Classification tests that Category and Bounce are derived from the same status table
+func TestSMTPError_Classification(t *testing.T) {
+	for _, tc := range []struct {
+		enhanced string
+		category string
+		bounce   BounceCategory
+	}{
+		{"5.1.10", "recipient address has null MX", MailboxUnavailable},
+		{"4.4.5", "mail system congestion", RateLimited},
+		{"4.7.28", "mail flood detected", RateLimited},
+		{"5.2.3", "message length exceeds administrative limit", BounceUnknown},
+		{"5.7.99", "security or policy status", PolicyRejection},
+		{"", "", BounceUnknown},
+	} {
+		err := &SMTPError{Code: 550, Enhanced: tc.enhanced}
+		if got := err.Category(); got != tc.category {
+			t.Errorf("%q: expected category %q, got %q", tc.enhanced, tc.category, got)
+		}
+		if got := err.Bounce(); got != tc.bounce {
+			t.Errorf("%q: expected bounce %v, got %v", tc.enhanced, tc.bounce, got)
+		}
+	}
+}
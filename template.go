@@ -0,0 +1,87 @@
+package email
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/mail"
+	"text/template"
+)
+
+// templates 解析后的主题和正文模板
+// 主题始终使用text/template；HTML正文使用html/template，对数据自动转义
+type templates struct {
+	subject *template.Template
+	body    func(buf *bytes.Buffer, data any) error
+}
+
+// parseTemplates 解析主题和正文模板，并注册Email上的模板函数
+func (m *Email) parseTemplates(subjectTmpl, bodyTmpl string, html bool) (*templates, error) {
+	subject, err := template.New("subject").Funcs(m.Funcs).Parse(subjectTmpl)
+	if err != nil {
+		return nil, err
+	}
+	t := &templates{subject: subject}
+	if html {
+		body, err := htmltemplate.New("body").Funcs(htmltemplate.FuncMap(m.Funcs)).Parse(bodyTmpl)
+		if err != nil {
+			return nil, err
+		}
+		t.body = func(buf *bytes.Buffer, data any) error { return body.Execute(buf, data) }
+	} else {
+		body, err := template.New("body").Funcs(m.Funcs).Parse(bodyTmpl)
+		if err != nil {
+			return nil, err
+		}
+		t.body = func(buf *bytes.Buffer, data any) error { return body.Execute(buf, data) }
+	}
+	return t, nil
+}
+
+// render 使用数据渲染主题和正文
+func (t *templates) render(data any) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := t.body(&body, data); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+// failAll 所有收件人记录同一个错误
+func failAll(toList []mail.Address, err error) []SendResult {
+	results := make([]SendResult, len(toList))
+	for i, addr := range toList {
+		results[i] = SendResult{Recipient: addr, Err: err}
+	}
+	return results
+}
+
+// SendTemplate 使用模板渲染主题和正文后发送，所有收件人使用相同的数据
+// isHTML为true时正文使用html/template渲染，数据会被自动转义
+func (m *Email) SendTemplate(fromName string, toList []mail.Address, subjectTmpl, bodyTmpl string, data any, isHTML ...bool) []SendResult {
+	opts := m.optionsOf(isHTML)
+	t, err := m.parseTemplates(subjectTmpl, bodyTmpl, opts.HTML)
+	if err != nil {
+		return failAll(toList, err)
+	}
+	subject, content, err := t.render(data)
+	if err != nil {
+		return failAll(toList, err)
+	}
+	return m.send(fromName, toList, staticContent(subject, content), opts, nil)
+}
+
+// SendPersonalized 为每个收件人渲染独立的主题和正文后发送
+// dataFor 返回该收件人的模板数据
+func (m *Email) SendPersonalized(fromName string, toList []mail.Address, subjectTmpl, bodyTmpl string, dataFor func(mail.Address) any, isHTML ...bool) []SendResult {
+	opts := m.optionsOf(isHTML)
+	t, err := m.parseTemplates(subjectTmpl, bodyTmpl, opts.HTML)
+	if err != nil {
+		return failAll(toList, err)
+	}
+	return m.send(fromName, toList, func(to mail.Address) (string, string, error) {
+		return t.render(dataFor(to))
+	}, opts, nil)
+}
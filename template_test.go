@@ -0,0 +1,58 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// TestEmail_TemplateFuncs tests that registered functions are available during rendering
+func TestEmail_TemplateFuncs(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	email.Funcs = template.FuncMap{
+		"upper": strings.ToUpper,
+		"default": func(def, value string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		},
+	}
+	results := email.SendPersonalized("测试发件人", []mail.Address{
+		{Name: "alice", Address: "alice@example.com"},
+		{Address: "bob@example.com"},
+	}, "Hello {{upper .Name}}", "Dear {{default \"customer\" .Name}}", func(to mail.Address) any {
+		return to
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+	}
+
+	received := strings.Join(server.received(), "\n")
+	for _, want := range []string{"Subject: Hello ALICE", "Dear alice", "Subject: Hello \r\n", "Dear customer"} {
+		if !strings.Contains(received, want) {
+			t.Errorf("expected %q in messages:\n%s", want, received)
+		}
+	}
+}
+
+// TestEmail_SendTemplateEscapesHTML tests that HTML bodies escape template data
+func TestEmail_SendTemplateEscapesHTML(t *testing.T) {
+	server := (&mockServer{extensions: []string{"AUTH LOGIN PLAIN"}}).start(t)
+
+	email := New(map[string]*ConfigMapper{"default": server.config(false)})
+	results := email.SendTemplate("测试发件人", []mail.Address{{Address: "rcpt@example.com"}},
+		"通知", "<p>{{.}}</p>", "<script>alert(1)</script>", true)
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+	received := server.received()
+	if len(received) != 1 || !strings.Contains(received[0], "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>") {
+		t.Errorf("expected escaped HTML body, got %q", received)
+	}
+}
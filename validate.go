@@ -0,0 +1,94 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Validate 序列化邮件并重新解析，在发送前发现邮件头、multipart边界或传输编码的结构问题
+// 不执行Email的中间件，检查的是邮件当前的内容
+func (msg *Message) Validate() error {
+	raw, err := serialize(msg, time.Now().Format(time.RFC1123Z), nil)
+	if err != nil {
+		return fmt.Errorf("email: invalid message: %w", err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("email: invalid message: %w", err)
+	}
+	for _, key := range []string{"From", "To"} {
+		if _, err := parsed.Header.AddressList(key); err != nil {
+			return fmt.Errorf("email: invalid message: header %s: %w", key, err)
+		}
+	}
+	for key, list := range map[string]AddressList{"Cc": msg.Cc, "Reply-To": msg.ReplyTo} {
+		if len(list) == 0 {
+			continue
+		}
+		if _, err := parsed.Header.AddressList(key); err != nil {
+			return fmt.Errorf("email: invalid message: header %s: %w", key, err)
+		}
+	}
+	if _, err := parsed.Header.Date(); err != nil {
+		return fmt.Errorf("email: invalid message: header Date: %w", err)
+	}
+	if err := validatePart(parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body); err != nil {
+		return fmt.Errorf("email: invalid message: %w", err)
+	}
+	return nil
+}
+
+// validatePart 检查一个MIME部分的内容类型和传输编码，multipart类型递归检查每个部分
+func validatePart(contentType, encoding string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("header Content-Type: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return validateEncoding(encoding, body)
+	}
+	if params["boundary"] == "" {
+		return errors.New("multipart without boundary")
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for i := 1; ; i++ {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+		if err = validatePart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part); err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+	}
+}
+
+// validateEncoding 按传输编码解码内容，确认内容可以被正确解码
+func validateEncoding(encoding string, body io.Reader) error {
+	var err error
+	switch strings.ToLower(encoding) {
+	case "", Encoding7Bit, Encoding8Bit:
+		_, err = io.Copy(io.Discard, body)
+	case EncodingQuotedPrintable:
+		_, err = io.Copy(io.Discard, quotedprintable.NewReader(body))
+	case EncodingBase64:
+		_, err = io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, body))
+	default:
+		return fmt.Errorf("unsupported transfer encoding %q", encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s content: %w", encoding, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package email
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// TestMessage_Validate tests that Validate accepts a valid message and rejects header injection
+func TestMessage_Validate(t *testing.T) {
+	msg := &Message{
+		From:        mail.Address{Name: "发件人", Address: "sender@example.com"},
+		To:          []mail.Address{{Address: "a@example.com"}, {Name: "B", Address: "b@example.com"}},
+		Subject:     "subject",
+		Body:        "正文",
+		Attachments: []*Attachment{NewAttachment("report.pdf", []byte("%PDF-1.4"))},
+	}
+	if err := msg.Validate(); err != nil {
+		t.Fatalf("unexpected error for valid message: %v", err)
+	}
+
+	msg.Header = textproto.MIMEHeader{"X-Campaign": {"spring\r\nBcc: victim@example.com"}}
+	err := msg.Validate()
+	if err == nil {
+		t.Fatal("expected error for header value containing CRLF")
+	}
+	if !strings.Contains(err.Error(), "X-Campaign") {
+		t.Errorf("expected error to name the offending header, got %v", err)
+	}
+}
+
+// TestMessage_ValidateNoRecipients tests that Validate requires a To header
+func TestMessage_ValidateNoRecipients(t *testing.T) {
+	msg := &Message{From: mail.Address{Address: "sender@example.com"}, Subject: "subject", Body: "content"}
+	err := msg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "header To") {
+		t.Errorf("expected To header error, got %v", err)
+	}
+}
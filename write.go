@@ -0,0 +1,100 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// WriteMessage写出邮件时的换行符
+const (
+	LineEndingCRLF = ""   // CRLF，与SMTP传输的内容相同，RFC 5322要求的格式
+	LineEndingLF   = "lf" // LF，用于要求Unix换行符的本地存储（如部分Maildir实现）
+)
+
+// WriteMessage 构建邮件并写入w而不是通过SMTP发送，用于保存到Maildir、文件或调试
+// 与发送时一样执行中间件并添加X-Mailer等邮件头，换行符由LineEnding决定；
+// 只转换邮件头、multipart分隔线和文本内容的换行符，binary编码的部分原样写出
+func (m *Email) WriteMessage(w io.Writer, msg *Message) error {
+	message, err := m.prepare(msg, envelopeOf(msg))
+	if err != nil {
+		return err
+	}
+	var eol string
+	switch m.LineEnding {
+	case LineEndingCRLF:
+		eol = "\r\n"
+	case LineEndingLF:
+		eol = "\n"
+	default:
+		return fmt.Errorf("email: unsupported line ending %q", m.LineEnding)
+	}
+	var out bytes.Buffer
+	writeEntity(&out, message, eol)
+	message = out.Bytes()
+	if _, err = w.Write(message); err != nil {
+		return fmt.Errorf("email: failed to write message: %w", err)
+	}
+	return nil
+}
+
+// writeEntity 按eol写出序列化后的MIME实体：邮件头和非binary编码的内容先统一为LF再转换为eol，
+// 与SMTP传输时DotWriter对7bit和8bit正文的处理一致；multipart按边界逐个处理各部分，binary编码的内容原样写出
+func writeEntity(out *bytes.Buffer, entity []byte, eol string) {
+	var header, body []byte
+	if rest, ok := bytes.CutPrefix(entity, []byte("\r\n")); ok {
+		// 没有邮件头的部分
+		body = rest
+	} else if header, body, ok = bytes.Cut(entity, []byte("\r\n\r\n")); !ok {
+		out.Write(convertEOL(entity, eol))
+		return
+	}
+	out.Write(convertEOL(header, eol))
+	if len(header) > 0 {
+		out.WriteString(eol)
+	}
+	out.WriteString(eol)
+
+	fields, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(header, "\r\n\r\n"...)))).ReadMIMEHeader()
+	mediaType, params, _ := mime.ParseMediaType(fields.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		// 边界不会出现在任一部分的内容中，可以直接按分隔线切分
+		sections := bytes.Split(append([]byte("\r\n"), body...), []byte("\r\n--"+params["boundary"]))
+		if preamble := sections[0]; len(preamble) > 0 {
+			out.Write(convertEOL(preamble[2:], eol))
+			out.WriteString(eol)
+		}
+		for i, section := range sections[1:] {
+			if i > 0 {
+				out.WriteString(eol)
+			}
+			out.WriteString("--" + params["boundary"])
+			if epilogue, ok := bytes.CutPrefix(section, []byte("--")); ok {
+				out.WriteString("--")
+				out.Write(convertEOL(epilogue, eol))
+				break
+			}
+			part, _ := bytes.CutPrefix(section, []byte("\r\n"))
+			out.WriteString(eol)
+			writeEntity(out, part, eol)
+		}
+	case strings.EqualFold(fields.Get("Content-Transfer-Encoding"), "binary"):
+		out.Write(body)
+	default:
+		out.Write(convertEOL(body, eol))
+	}
+}
+
+// convertEOL 将内容中的CRLF和LF统一转换为eol
+func convertEOL(content []byte, eol string) []byte {
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	if eol == "\n" {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\n"), []byte(eol))
+}
@@ -0,0 +1,111 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// TestEmail_WriteMessage tests writing a message with LF and CRLF line endings
+func TestEmail_WriteMessage(t *testing.T) {
+	msg := &Message{
+		From:             mail.Address{Address: "sender@example.com"},
+		To:               []mail.Address{{Address: "user@example.com"}},
+		Subject:          "归档",
+		Body:             "第一行\n第二行\r\n第三行",
+		TransferEncoding: Encoding8Bit,
+		Attachments:      []*Attachment{NewAttachment("data.bin", bytes.Repeat([]byte{0xff}, 100))},
+	}
+
+	var lf bytes.Buffer
+	if err := (&Email{LineEnding: LineEndingLF}).WriteMessage(&lf, msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.IndexByte(lf.Bytes(), '\r') >= 0 {
+		t.Errorf("expected no CR bytes in LF mode:\n%q", lf.String())
+	}
+	if !strings.Contains(lf.String(), "第一行\n第二行\n第三行") {
+		t.Errorf("expected body lines with LF:\n%q", lf.String())
+	}
+
+	var crlf bytes.Buffer
+	if err := (&Email{}).WriteMessage(&crlf, msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := crlf.Bytes()
+	if bytes.Count(out, []byte("\n")) != bytes.Count(out, []byte("\r\n")) || bytes.Count(out, []byte("\n")) == 0 {
+		t.Errorf("expected CRLF throughout in CRLF mode:\n%q", crlf.String())
+	}
+
+	if err := (&Email{LineEnding: "cr"}).WriteMessage(&crlf, msg); err == nil {
+		t.Error("expected error for unsupported line ending")
+	}
+}
+
+// TestEmail_WriteMessageBinaryPart tests that a binary part survives both line endings byte for byte
+func TestEmail_WriteMessageBinaryPart(t *testing.T) {
+	payload := []byte{0x00, '\r', '\n', 0xff, '\n', '\r', 0x01, '\r', '\n'}
+	msg := &Message{
+		From:             mail.Address{Address: "sender@example.com"},
+		To:               []mail.Address{{Address: "user@example.com"}},
+		Subject:          "归档",
+		Body:             "第一行\n第二行",
+		TransferEncoding: Encoding8Bit,
+		Parts: []*MIMEPart{{
+			Header: textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}, "Content-Transfer-Encoding": {"binary"}},
+			Body:   payload,
+		}},
+	}
+	for _, tc := range []struct {
+		lineEnding string
+		eol        string
+	}{
+		{LineEndingCRLF, "\r\n"},
+		{LineEndingLF, "\n"},
+	} {
+		var buf bytes.Buffer
+		if err := (&Email{LineEnding: tc.lineEnding}).WriteMessage(&buf, msg); err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.lineEnding, err)
+		}
+		if bytes.Count(buf.Bytes(), payload) != 1 {
+			t.Fatalf("%q: expected the binary part unchanged:\n%q", tc.lineEnding, buf.String())
+		}
+		// 除binary部分外的内容都使用eol
+		rest := strings.ReplaceAll(strings.Replace(buf.String(), string(payload), "", 1), tc.eol, "")
+		if strings.ContainsAny(rest, "\r\n") {
+			t.Errorf("%q: expected only %q line endings outside the binary part:\n%q", tc.lineEnding, tc.eol, buf.String())
+		}
+		if !strings.Contains(buf.String(), "第一行"+tc.eol+"第二行") {
+			t.Errorf("%q: expected body lines converted:\n%q", tc.lineEnding, buf.String())
+		}
+		if tc.lineEnding != LineEndingCRLF {
+			continue
+		}
+		parsed, err := mail.ReadMessage(&buf)
+		if err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		mr := multipart.NewReader(parsed.Body, params["boundary"])
+		var bodies [][]byte
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			body, _ := io.ReadAll(part)
+			bodies = append(bodies, body)
+		}
+		if len(bodies) != 2 || !bytes.Equal(bodies[1], payload) {
+			t.Errorf("expected the binary part to round-trip, got %q", bodies)
+		}
+	}
+}